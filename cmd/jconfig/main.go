@@ -0,0 +1,172 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// jconfig 配置文件工具
+//
+//	jconfig sample -struct web -type toml -out config.toml
+//	go build -tags jorm ./cmd/jconfig 后可使用 -struct orm, 见sampleOrm.go
+//	jconfig genkey -out config.key
+//	jconfig encrypt -key-file config.key "user:password@tcp(127.0.0.1:3306)/db"
+//	jconfig decrypt -key-env JCONFIG_KEY "ENC(...)"
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/liyiligang/base/component/Jconfig"
+	"github.com/liyiligang/base/component/Jlog"
+	"github.com/liyiligang/base/component/Jrpc"
+	"github.com/liyiligang/base/component/Jweb"
+	"os"
+	"sort"
+	"strings"
+)
+
+var sampleStructMap = map[string]interface{}{
+	"web":       Jweb.WebConfig{},
+	"log":       Jlog.LogConfig{},
+	"rpcserver": Jrpc.RpcServerConfig{},
+	"rpcclient": Jrpc.RpcClientConfig{},
+}
+
+var commandMap = map[string]func(args []string) error{
+	"sample":  runSample,
+	"genkey":  runGenKey,
+	"encrypt": runEncrypt,
+	"decrypt": runDecrypt,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commandMap[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "jconfig "+os.Args[1]+": "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	commandList := make([]string, 0, len(commandMap))
+	for name := range commandMap {
+		commandList = append(commandList, name)
+	}
+	sort.Strings(commandList)
+	fmt.Fprintln(os.Stderr, "usage: jconfig <"+strings.Join(commandList, "|")+"> [flags]")
+}
+
+func runSample(args []string) error {
+	structList := make([]string, 0, len(sampleStructMap))
+	for name := range sampleStructMap {
+		structList = append(structList, name)
+	}
+	sort.Strings(structList)
+	flagSet := flag.NewFlagSet("sample", flag.ExitOnError)
+	structName := flagSet.String("struct", "", "config struct: "+strings.Join(structList, ", "))
+	configType := flagSet.String("type", Jconfig.ConfigTypeToml, "output format: toml, yaml, json")
+	out := flagSet.String("out", "", "output file, default stdout")
+	flagSet.Parse(args)
+	config, ok := sampleStructMap[*structName]
+	if !ok {
+		return errors.New("unknown struct " + *structName + ", expected one of " + strings.Join(structList, ", "))
+	}
+	data, err := Jconfig.GenerateSampleConfig(config, *configType)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, data)
+}
+
+func writeOutput(out string, data []byte) error {
+	if out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+func runGenKey(args []string) error {
+	flagSet := flag.NewFlagSet("genkey", flag.ExitOnError)
+	out := flagSet.String("out", "", "output file, default stdout")
+	flagSet.Parse(args)
+	key, err := Jconfig.NewConfigCryptKey()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return writeOutput("", []byte(key+"\n"))
+	}
+	return os.WriteFile(*out, []byte(key+"\n"), 0600)
+}
+
+func runEncrypt(args []string) error {
+	key, value, err := parseCryptArgs("encrypt", args)
+	if err != nil {
+		return err
+	}
+	res, err := Jconfig.EncryptConfigValue(key, value)
+	if err != nil {
+		return err
+	}
+	return writeOutput("", []byte(res+"\n"))
+}
+
+func runDecrypt(args []string) error {
+	key, value, err := parseCryptArgs("decrypt", args)
+	if err != nil {
+		return err
+	}
+	res, err := Jconfig.DecryptConfigValue(key, value)
+	if err != nil {
+		return err
+	}
+	return writeOutput("", []byte(res+"\n"))
+}
+
+// 待处理的值取自第一个参数, 没有参数时从标准输入读取一行
+func parseCryptArgs(name string, args []string) ([]byte, string, error) {
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
+	keyFile := flagSet.String("key-file", "", "key file path")
+	keyEnv := flagSet.String("key-env", Jconfig.ConfigCryptKeyEnv, "env name of the key, used when -key-file is empty")
+	flagSet.Parse(args)
+	var key []byte
+	var err error
+	if *keyFile != "" {
+		key, err = Jconfig.ReadConfigCryptKeyFromFile(*keyFile)
+	} else {
+		key, err = Jconfig.ReadConfigCryptKeyFromEnv(*keyEnv)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if flagSet.NArg() > 0 {
+		return key, flagSet.Arg(0), nil
+	}
+	reader := bufio.NewReader(os.Stdin)
+	value, err := reader.ReadString('\n')
+	if err != nil && value == "" {
+		return nil, "", errors.New("value is empty")
+	}
+	return key, strings.TrimRight(value, "\r\n"), nil
+}
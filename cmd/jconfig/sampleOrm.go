@@ -0,0 +1,26 @@
+//go:build jorm
+
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "github.com/liyiligang/base/component/Jorm"
+
+// Jorm依赖的gorm数据库驱动与当前go.mod中的依赖版本不兼容, 需要orm示例时使用 go build -tags jorm
+func init() {
+	sampleStructMap["orm"] = Jorm.OrmConfig{}
+}
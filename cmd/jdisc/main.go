@@ -0,0 +1,398 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// jdisc 服务发现和配置工具, 默认连接127.0.0.1:2379, -file使用本地的静态文件代替etcd, 此时文件只读, 不能使用put, del和import
+//
+//	jdisc list -endpoints 10.0.0.1:2379,10.0.0.2:2379 -prefix /service/user
+//	jdisc show /service/user/1
+//	jdisc get /config/app
+//	jdisc put -comment "raise pool size" /config/app < app.toml
+//	jdisc del /config/app
+//	jdisc watch -prefix /service
+//	jdisc export -prefix /config -dir ./config
+//	jdisc import -prefix /config -dir ./config
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/liyiligang/base/component/Jdiscovery"
+	"go.etcd.io/etcd/client/v3"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+var commandMap = map[string]func(args []string) error{
+	"list":   runList,
+	"show":   runShow,
+	"get":    runGet,
+	"put":    runPut,
+	"del":    runDel,
+	"watch":  runWatch,
+	"export": runExport,
+	"import": runImport,
+}
+
+// discoveryFlags 所有子命令共用的连接参数
+type discoveryFlags struct {
+	endpoints *string
+	username  *string
+	password  *string
+	caPath    *string
+	certPath  *string
+	keyPath   *string
+	namespace *string
+	timeout   *int
+	filePath  *string
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commandMap[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "jdisc "+os.Args[1]+": "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	commandList := make([]string, 0, len(commandMap))
+	for name := range commandMap {
+		commandList = append(commandList, name)
+	}
+	sort.Strings(commandList)
+	fmt.Fprintln(os.Stderr, "usage: jdisc <"+strings.Join(commandList, "|")+"> [flags]")
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *discoveryFlags) {
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
+	return flagSet, &discoveryFlags{
+		endpoints: flagSet.String("endpoints", "127.0.0.1:2379", "etcd endpoints, separated by comma"),
+		username:  flagSet.String("user", "", "etcd username"),
+		password:  flagSet.String("password", "", "etcd password"),
+		caPath:    flagSet.String("cacert", "", "etcd CA certificate path"),
+		certPath:  flagSet.String("cert", "", "etcd client certificate path"),
+		keyPath:   flagSet.String("key", "", "etcd client private key path"),
+		namespace: flagSet.String("namespace", "", "etcd key namespace"),
+		timeout:   flagSet.Int("timeout", 5, "connect and request timeout in seconds"),
+		filePath:  flagSet.String("file", "", "use a read-only static JSON/TOML discovery file instead of etcd"),
+	}
+}
+
+func (flags *discoveryFlags) connect() (*Jdiscovery.Discovery, error) {
+	config := Jdiscovery.DiscoveryInitConfig{
+		EtcdAddr:       *flags.endpoints,
+		ConnectTimeout: *flags.timeout,
+		RequestTimeout: *flags.timeout,
+		Username:       *flags.username,
+		Password:       *flags.password,
+		CAPath:         *flags.caPath,
+		PublicKeyPath:  *flags.certPath,
+		PrivateKeyPath: *flags.keyPath,
+		Namespace:      *flags.namespace,
+	}
+	if *flags.filePath == "" {
+		return Jdiscovery.DiscoveryInit(config)
+	}
+	backend, err := Jdiscovery.NewFileBackend(*flags.filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Jdiscovery.DiscoveryInitWithBackend(config, backend)
+}
+
+// connectWritable 写命令只能用于etcd, -file的修改只保存在内存中, 不会写回文件
+func (flags *discoveryFlags) connectWritable() (*Jdiscovery.Discovery, error) {
+	if *flags.filePath != "" {
+		return nil, errors.New("discovery file is read-only, put, del and import require etcd")
+	}
+	return flags.connect()
+}
+
+func getKeyArg(flagSet *flag.FlagSet) (string, error) {
+	if flagSet.NArg() < 1 || flagSet.Arg(0) == "" {
+		return "", errors.New("key is empty")
+	}
+	return flagSet.Arg(0), nil
+}
+
+// runList 列出前缀下的节点, 节点数据为ServiceInstance时按列输出, 否则输出原始数据
+func runList(args []string) error {
+	flagSet, flags := newFlagSet("list")
+	prefix := flagSet.String("prefix", "/", "key prefix")
+	flagSet.Parse(args)
+	discovery, err := flags.connect()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	dataMap, _, err := discovery.GetDataWithPrefix(*prefix)
+	if err != nil {
+		return err
+	}
+	keyList := make([]string, 0, len(dataMap))
+	for key := range dataMap {
+		keyList = append(keyList, key)
+	}
+	sort.Strings(keyList)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tNAME\tENDPOINT\tVERSION\tZONE\tWEIGHT\tHEALTH\tTAGS")
+	for _, key := range keyList {
+		instance, err := Jdiscovery.ParseServiceInstance(dataMap[key])
+		if err != nil || instance.ID == "" {
+			fmt.Fprintf(writer, "%v\t%v\n", key, summaryData(dataMap[key]))
+			continue
+		}
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", key, instance.Name, instance.Endpoint(),
+			instance.Version, instance.Zone, instance.Weight, instance.Health, strings.Join(instance.Tags, ","))
+	}
+	return writer.Flush()
+}
+
+// runShow 格式化输出节点数据, json数据缩进输出
+func runShow(args []string) error {
+	flagSet, flags := newFlagSet("show")
+	flagSet.Parse(args)
+	key, err := getKeyArg(flagSet)
+	if err != nil {
+		return err
+	}
+	discovery, err := flags.connect()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	data, err := discovery.GetData(key)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, data, "", "  ") != nil {
+		out.Reset()
+		out.Write(data)
+	}
+	out.WriteString("\n")
+	_, err = os.Stdout.Write(out.Bytes())
+	return err
+}
+
+func runGet(args []string) error {
+	flagSet, flags := newFlagSet("get")
+	showVersion := flagSet.Bool("version", false, "print the config version to stderr")
+	flagSet.Parse(args)
+	key, err := getKeyArg(flagSet)
+	if err != nil {
+		return err
+	}
+	discovery, err := flags.connect()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	data, version, err := discovery.GetConfigWithVersion(key)
+	if err != nil {
+		return err
+	}
+	if *showVersion {
+		fmt.Fprintln(os.Stderr, "version:", version)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// runPut 值取自第二个参数, 没有时读取标准输入, -if-version不为-1时只在版本一致时写入
+func runPut(args []string) error {
+	flagSet, flags := newFlagSet("put")
+	author := flagSet.String("author", "", "author recorded in config history, default user@host")
+	comment := flagSet.String("comment", "", "comment recorded in config history")
+	ifVersion := flagSet.Int64("if-version", -1, "only write when the current version matches, 0 means the key must not exist")
+	flagSet.Parse(args)
+	key, err := getKeyArg(flagSet)
+	if err != nil {
+		return err
+	}
+	var value string
+	if flagSet.NArg() > 1 {
+		value = flagSet.Arg(1)
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	discovery, err := flags.connectWritable()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	change := Jdiscovery.DiscoveryConfigChange{Author: *author, Comment: *comment}
+	var version int64
+	if *ifVersion >= 0 {
+		version, err = discovery.SetConfigIfVersion(key, value, *ifVersion, change)
+	} else {
+		version, err = discovery.SetConfigWithChange(key, value, change)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "version:", version)
+	return nil
+}
+
+func runDel(args []string) error {
+	flagSet, flags := newFlagSet("del")
+	flagSet.Parse(args)
+	key, err := getKeyArg(flagSet)
+	if err != nil {
+		return err
+	}
+	discovery, err := flags.connectWritable()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	return discovery.DelData(key)
+}
+
+// runWatch 持续输出前缀下的变化, 直到收到中断信号
+func runWatch(args []string) error {
+	flagSet, flags := newFlagSet("watch")
+	prefix := flagSet.String("prefix", "/", "key prefix")
+	flagSet.Parse(args)
+	discovery, err := flags.connect()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	discovery.WatchDataWithPrefix(ctx, *prefix, 0, func(ev *clientv3.Event) {
+		data := ev.Kv.Value
+		if ev.Type == clientv3.EventTypeDelete && ev.PrevKv != nil {
+			data = ev.PrevKv.Value
+		}
+		fmt.Printf("%v %-6v %v %v\n", time.Now().Format("2006-01-02 15:04:05"), ev.Type, string(ev.Kv.Key),
+			summaryData(data))
+	})
+	return nil
+}
+
+// runExport 将前缀下的每个键写入dir下与键相对路径对应的文件
+func runExport(args []string) error {
+	flagSet, flags := newFlagSet("export")
+	prefix := flagSet.String("prefix", "", "key prefix")
+	dir := flagSet.String("dir", ".", "output directory")
+	flagSet.Parse(args)
+	if *prefix == "" {
+		return errors.New("prefix is empty")
+	}
+	discovery, err := flags.connect()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	keyPrefix := strings.TrimSuffix(*prefix, "/") + "/"
+	dataMap, _, err := discovery.GetDataWithPrefix(keyPrefix)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for key, data := range dataMap {
+		relPath := strings.Trim(strings.TrimPrefix(key, keyPrefix), "/")
+		if relPath == "" || !filepath.IsLocal(filepath.FromSlash(relPath)) {
+			fmt.Fprintln(os.Stderr, "skip key", key)
+			continue
+		}
+		filePath := filepath.Join(*dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return err
+		}
+		count++
+	}
+	fmt.Fprintln(os.Stderr, "exported", count, "keys")
+	return nil
+}
+
+// runImport 将dir下的每个文件按相对路径写入前缀下的键, 并记录配置历史
+func runImport(args []string) error {
+	flagSet, flags := newFlagSet("import")
+	prefix := flagSet.String("prefix", "", "key prefix")
+	dir := flagSet.String("dir", ".", "input directory")
+	comment := flagSet.String("comment", "import", "comment recorded in config history")
+	flagSet.Parse(args)
+	if *prefix == "" {
+		return errors.New("prefix is empty")
+	}
+	discovery, err := flags.connectWritable()
+	if err != nil {
+		return err
+	}
+	defer discovery.Close(context.Background())
+	count := 0
+	err = filepath.WalkDir(*dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(*dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSuffix(*prefix, "/") + "/" + filepath.ToSlash(relPath)
+		_, err = discovery.SetConfigWithChange(key, string(data), Jdiscovery.DiscoveryConfigChange{Comment: *comment})
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	fmt.Fprintln(os.Stderr, "imported", count, "keys")
+	return err
+}
+
+// summaryData 单行输出数据, 过长时截断
+func summaryData(data []byte) string {
+	str := strings.Join(strings.Fields(string(data)), " ")
+	if runeList := []rune(str); len(runeList) > 80 {
+		str = string(runeList[:77]) + "..."
+	}
+	return str
+}
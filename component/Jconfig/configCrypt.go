@@ -0,0 +1,207 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 配置值为 ENC(base64) 时, 在解析前使用AES-GCM解密, base64内容为 nonce + 密文
+// 密钥为16, 24或32字节, 可以是原始字节或其base64编码
+// 未调用SetConfigCryptKey时, 依次从环境变量JCONFIG_KEY(密钥内容)和JCONFIG_KEY_FILE(密钥文件路径)读取
+const (
+	ConfigCryptKeyEnv     = "JCONFIG_KEY"
+	ConfigCryptKeyFileEnv = "JCONFIG_KEY_FILE"
+	configCryptPrefix     = "ENC("
+	configCryptSuffix     = ")"
+)
+
+var configCryptKey []byte
+var configCryptKeyLock sync.RWMutex
+
+// SetConfigCryptKey 设置解密配置值使用的密钥, key按ParseConfigCryptKey解析
+func SetConfigCryptKey(key []byte) error {
+	key, err := ParseConfigCryptKey(key)
+	if err != nil {
+		return err
+	}
+	storeConfigCryptKey(key)
+	return nil
+}
+
+// SetConfigCryptKeyFromFile 从文件读取解密配置值使用的密钥
+func SetConfigCryptKeyFromFile(filePath string) error {
+	key, err := ReadConfigCryptKeyFromFile(filePath)
+	if err != nil {
+		return err
+	}
+	storeConfigCryptKey(key)
+	return nil
+}
+
+// SetConfigCryptKeyFromEnv 从环境变量读取解密配置值使用的密钥
+func SetConfigCryptKeyFromEnv(envName string) error {
+	key, err := ReadConfigCryptKeyFromEnv(envName)
+	if err != nil {
+		return err
+	}
+	storeConfigCryptKey(key)
+	return nil
+}
+
+func ReadConfigCryptKeyFromFile(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfigCryptKey(data)
+}
+
+func ReadConfigCryptKeyFromEnv(envName string) ([]byte, error) {
+	val, ok := os.LookupEnv(envName)
+	if !ok {
+		return nil, errors.New("env " + envName + " is not set")
+	}
+	return ParseConfigCryptKey([]byte(val))
+}
+
+// ParseConfigCryptKey 解析文本形式的密钥, 优先按base64解码, 解码结果不是16, 24或32字节时才将原文作为密钥
+// 例如 openssl rand -base64 16 生成的24个字符按base64解码为16字节的密钥, 而不是作为24字节的原文
+func ParseConfigCryptKey(key []byte) ([]byte, error) {
+	key = bytes.TrimSpace(key)
+	decodeKey, err := base64.StdEncoding.DecodeString(string(key))
+	if err == nil && isConfigCryptKeySize(len(decodeKey)) {
+		return decodeKey, nil
+	}
+	if isConfigCryptKeySize(len(key)) {
+		return key, nil
+	}
+	return nil, errors.New("config crypt key must be the base64 encoding of 16, 24 or 32 bytes, or 16, 24 or 32 raw bytes")
+}
+
+// NewConfigCryptKey 生成随机的32字节密钥, 返回其base64编码
+func NewConfigCryptKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// EncryptConfigValue 加密配置值, 返回 ENC(base64) 格式的字符串, key为ParseConfigCryptKey解析后的密钥
+func EncryptConfigValue(key []byte, value string) (string, error) {
+	gcm, err := newConfigCrypt(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return configCryptPrefix + base64.StdEncoding.EncodeToString(data) + configCryptSuffix, nil
+}
+
+// DecryptConfigValue 解密 ENC(base64) 格式的配置值, key为ParseConfigCryptKey解析后的密钥
+func DecryptConfigValue(key []byte, value string) (string, error) {
+	if !IsEncryptConfigValue(value) {
+		return "", errors.New("config value is not in ENC(...) format")
+	}
+	gcm, err := newConfigCrypt(key)
+	if err != nil {
+		return "", err
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, configCryptPrefix), configCryptSuffix)
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("config value is too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func IsEncryptConfigValue(value string) bool {
+	return strings.HasPrefix(value, configCryptPrefix) && strings.HasSuffix(value, configCryptSuffix)
+}
+
+// newConfigCrypt key已经解析过, 不再按base64解码, 避免原文恰好是合法base64时被解码两次
+func newConfigCrypt(key []byte) (cipher.AEAD, error) {
+	if !isConfigCryptKeySize(len(key)) {
+		return nil, errors.New("config crypt key must be 16, 24 or 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func storeConfigCryptKey(key []byte) {
+	configCryptKeyLock.Lock()
+	configCryptKey = key
+	configCryptKeyLock.Unlock()
+}
+
+func decryptConfigString(str string) (string, error) {
+	if !IsEncryptConfigValue(str) {
+		return str, nil
+	}
+	key, err := getConfigCryptKey()
+	if err != nil {
+		return "", err
+	}
+	plain, err := DecryptConfigValue(key, str)
+	if err != nil {
+		return "", errors.New("decrypt failed: " + err.Error())
+	}
+	return plain, nil
+}
+
+func getConfigCryptKey() ([]byte, error) {
+	configCryptKeyLock.RLock()
+	key := configCryptKey
+	configCryptKeyLock.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	if _, ok := os.LookupEnv(ConfigCryptKeyEnv); ok {
+		return ReadConfigCryptKeyFromEnv(ConfigCryptKeyEnv)
+	}
+	if filePath, ok := os.LookupEnv(ConfigCryptKeyFileEnv); ok {
+		return ReadConfigCryptKeyFromFile(filePath)
+	}
+	return nil, errors.New("config crypt key is not set")
+}
+
+func isConfigCryptKeySize(size int) bool {
+	return size == 16 || size == 24 || size == 32
+}
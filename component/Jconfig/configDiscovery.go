@@ -0,0 +1,148 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"bytes"
+	"errors"
+	"github.com/liyiligang/base/component/Jdiscovery"
+	"sync"
+	"sync/atomic"
+)
+
+// ConfigDiscoveryBind 将etcd中的配置键绑定为类型T, 配置变化时解析并校验后原子替换
+// 解析或校验失败时保留上一次有效的配置, ConfigRestore为true时同时将其写回etcd
+// CachePath不为空时在本地缓存通过解析和校验的配置, etcd不可用时从缓存启动, 见Jdiscovery.DiscoveryConfig
+type ConfigDiscoveryBind[T any] struct {
+	ConfigKey     string
+	ConfigType    string
+	CachePath     string
+	ConfigCheck   func(config *T) error
+	ConfigRestore bool
+	ErrorCall     func(err error)
+	discovery     *Jdiscovery.Discovery
+	config        atomic.Pointer[T]
+	configData    []byte
+	callLock      sync.RWMutex
+	callList      []func(oldConfig *T, newConfig *T)
+}
+
+// BindDiscoveryConfig 读取并监听bind.ConfigKey, 首次读取的配置无效时返回错误
+func BindDiscoveryConfig[T any](discovery *Jdiscovery.Discovery, bind *ConfigDiscoveryBind[T]) error {
+	if discovery == nil {
+		return errors.New("discovery is nil")
+	}
+	bind.discovery = discovery
+	var initErr error
+	isInit := true
+	err := discovery.RegisterConfigWatch(&Jdiscovery.DiscoveryConfig{
+		ConfigKey: bind.ConfigKey,
+		CachePath: bind.CachePath,
+		ConfigCheck: func(data []byte) error {
+			_, err := bind.decode(data)
+			return err
+		},
+		ConfigCall: func(oldConfig []byte, newConfig []byte) {
+			err := bind.update(newConfig)
+			if isInit {
+				isInit = false
+				initErr = err
+				return
+			}
+			if err != nil {
+				bind.error(err)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if initErr != nil {
+		discovery.UnRegisterConfigWatch(bind.ConfigKey)
+		return initErr
+	}
+	return nil
+}
+
+// Get 获取当前生效的配置
+func (bind *ConfigDiscoveryBind[T]) Get() *T {
+	return bind.config.Load()
+}
+
+// Subscribe 订阅配置变化, 仅在新配置生效后调用
+func (bind *ConfigDiscoveryBind[T]) Subscribe(call func(oldConfig *T, newConfig *T)) {
+	bind.callLock.Lock()
+	bind.callList = append(bind.callList, call)
+	bind.callLock.Unlock()
+}
+
+func (bind *ConfigDiscoveryBind[T]) Close() error {
+	return bind.discovery.UnRegisterConfigWatch(bind.ConfigKey)
+}
+
+func (bind *ConfigDiscoveryBind[T]) update(data []byte) error {
+	if bind.config.Load() != nil && bytes.Equal(data, bind.configData) {
+		return nil
+	}
+	newConfig, err := bind.decode(data)
+	if err != nil {
+		bind.restore()
+		return errors.New("config " + bind.ConfigKey + " is invalid: " + err.Error())
+	}
+	bind.configData = data
+	oldConfig := bind.config.Swap(newConfig)
+	bind.callLock.RLock()
+	defer bind.callLock.RUnlock()
+	for _, call := range bind.callList {
+		call(oldConfig, newConfig)
+	}
+	return nil
+}
+
+func (bind *ConfigDiscoveryBind[T]) decode(data []byte) (*T, error) {
+	if data == nil {
+		return nil, errors.New("config is deleted")
+	}
+	newConfig := new(T)
+	if err := ReadConfigFromByteWithType(newConfig, data, bind.ConfigType); err != nil {
+		return nil, err
+	}
+	if err := ValidateConfig(newConfig); err != nil {
+		return nil, err
+	}
+	if bind.ConfigCheck != nil {
+		if err := bind.ConfigCheck(newConfig); err != nil {
+			return nil, err
+		}
+	}
+	return newConfig, nil
+}
+
+func (bind *ConfigDiscoveryBind[T]) restore() {
+	if !bind.ConfigRestore || bind.config.Load() == nil {
+		return
+	}
+	if err := bind.discovery.SetConfig(bind.ConfigKey, string(bind.configData)); err != nil {
+		bind.error(err)
+	}
+}
+
+func (bind *ConfigDiscoveryBind[T]) error(err error) {
+	if bind.ErrorCall != nil {
+		bind.ErrorCall(err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ConfigLayer 分层配置, 优先级由低到高依次为:
+//  1. 调用前结构体中已填充的默认值
+//  2. 配置文件 (FilePath, 为空时读取Path目录下的config.toml, 与ReadConfigFromPath一致)
+//     之后依次合并Profile覆盖文件(例如config.dev.toml)和ConfDir目录下的配置文件, map递归合并, 其他值(包括切片)整体替换
+//  3. 环境变量 (仅在EnvPrefix不为空时生效, 例如前缀APP, 配置键db.sqlDsn对应APP_DB_SQLDSN)
+//  4. 命令行参数 (FlagSet中被显式设置的参数, 参数名为配置键, 例如--db.sqlDsn或--db-sqlDsn, 不区分大小写)
+type ConfigLayer struct {
+	Path      string
+	FilePath  string
+	Profile   string
+	ConfDir   string
+	EnvPrefix string
+	FlagSet   *pflag.FlagSet
+}
+
+// ReadConfigWithLayer 按ConfigLayer中的优先级合并各层配置并写入config
+func ReadConfigWithLayer(config interface{}, layer ConfigLayer) error {
+	v, err := layer.newViper(config)
+	if err != nil {
+		return err
+	}
+	return decodeConfig(v, config)
+}
+
+func (layer *ConfigLayer) newViper(config interface{}) (*viper.Viper, error) {
+	v := viper.New()
+	fields := getConfigFields(config)
+	for _, field := range fields {
+		if field.nilParent || isNilConfigValue(field.value) {
+			continue
+		}
+		v.SetDefault(field.key, field.value.Interface())
+	}
+	if err := layer.readConfigFile(v); err != nil {
+		return nil, err
+	}
+	if layer.EnvPrefix != "" {
+		v.SetEnvPrefix(layer.EnvPrefix)
+		v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+		for _, field := range fields {
+			if err := v.BindEnv(field.key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if layer.FlagSet != nil {
+		if err := layer.bindFlags(v, fields); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (layer *ConfigLayer) readConfigFile(v *viper.Viper) error {
+	filePath := layer.FilePath
+	if filePath == "" {
+		path := layer.Path
+		if path == "" {
+			path = "."
+		}
+		filePath = filepath.Join(path, "config."+ConfigTypeToml)
+	}
+	configMap, err := readConfigFileMap(filePath)
+	if err != nil {
+		if layer.FilePath != "" || !os.IsNotExist(err) || layer.Profile != "" {
+			return err
+		}
+		configMap = make(map[string]interface{})
+	}
+	if layer.Profile != "" {
+		profileMap, err := readConfigFileMap(getProfileFilePath(filePath, layer.Profile))
+		if err != nil {
+			return err
+		}
+		mergeConfigMap(configMap, profileMap)
+	}
+	if layer.ConfDir != "" {
+		fileList, err := getConfDirFileList(layer.ConfDir)
+		if err != nil {
+			return err
+		}
+		for _, confFile := range fileList {
+			confMap, err := readConfigFileMap(confFile)
+			if err != nil {
+				return err
+			}
+			mergeConfigMap(configMap, confMap)
+		}
+	}
+	return v.MergeConfigMap(configMap)
+}
+
+func (layer *ConfigLayer) bindFlags(v *viper.Viper, fields []configField) error {
+	flagMap := make(map[string]*pflag.Flag)
+	layer.FlagSet.VisitAll(func(flag *pflag.Flag) {
+		flagMap[strings.ToLower(strings.ReplaceAll(flag.Name, "-", "."))] = flag
+	})
+	for _, field := range fields {
+		flag, ok := flagMap[field.key]
+		if !ok {
+			continue
+		}
+		if err := v.BindPFlag(field.key, flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNilConfigValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return val.IsNil()
+	}
+	return false
+}
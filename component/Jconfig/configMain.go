@@ -19,12 +19,13 @@ package Jconfig
 import (
 	"bytes"
 	"github.com/spf13/viper"
+	"os"
 )
 
 func ReadConfigFromPath(config interface{}, path string) error {
 	viperPath := viper.New()
 	viperPath.SetConfigName("config")
-	viperPath.SetConfigType("toml")
+	viperPath.SetConfigType(ConfigTypeToml)
 	if path == "" {
 		path = "."
 	}
@@ -32,20 +33,59 @@ func ReadConfigFromPath(config interface{}, path string) error {
 	if err := viperPath.ReadInConfig(); err != nil {
 		return err
 	}
-	if err := viperPath.Unmarshal(config); err != nil {
+	return decodeConfig(viperPath, config)
+}
+
+func ReadConfigFromByte(config interface{}, data []byte) error {
+	return ReadConfigFromByteWithType(config, data, ConfigTypeToml)
+}
+
+// ReadConfigFromFile 读取指定配置文件, 格式由文件后缀决定, 后缀无法识别时根据文件内容判断
+func ReadConfigFromFile(config interface{}, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
 		return err
 	}
-	return nil
+	configType, err := GetConfigTypeFromPath(filePath)
+	if err != nil {
+		configType = ""
+	}
+	return ReadConfigFromByteWithType(config, data, configType)
 }
 
-func ReadConfigFromByte(config interface{}, data []byte) error {
+// ReadConfigFromByteWithType 按指定格式解析配置, configType为空时根据内容判断格式
+func ReadConfigFromByteWithType(config interface{}, data []byte, configType string) error {
+	viperByte, err := newViperFromByte(data, configType)
+	if err != nil {
+		return err
+	}
+	return decodeConfig(viperByte, config)
+}
+
+func newViperFromByte(data []byte, configType string) (*viper.Viper, error) {
+	if configType == "" {
+		detectType, err := GetConfigTypeFromByte(data)
+		if err != nil {
+			return nil, err
+		}
+		configType = detectType
+	}
+	configType, err := formatConfigType(configType)
+	if err != nil {
+		return nil, err
+	}
 	viperByte := viper.New()
-	viperByte.SetConfigType("toml")
+	viperByte.SetConfigType(configType)
 	if err := viperByte.ReadConfig(bytes.NewBuffer(data)); err != nil {
-		return err
+		return nil, err
 	}
-	if err := viperByte.Unmarshal(config); err != nil {
+	return viperByte, nil
+}
+
+// decodeConfig 解析引用和加密的值后写入config, 不做校验, 需要校验时在读取后调用ValidateConfig
+func decodeConfig(v *viper.Viper, config interface{}) error {
+	if err := resolveConfigValue(v); err != nil {
 		return err
 	}
-	return nil
+	return v.Unmarshal(config)
 }
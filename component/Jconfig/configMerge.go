@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadConfigWithProfile 读取基础配置文件, 合并profile覆盖文件和confDir目录下的配置文件
+// 例如 filePath为conf/config.toml, profile为dev时覆盖文件为conf/config.dev.toml
+// profile或confDir为空时跳过对应的步骤, 合并时map递归合并, 其他值(包括切片)整体替换
+func ReadConfigWithProfile(config interface{}, filePath string, profile string, confDir string) error {
+	return ReadConfigWithLayer(config, ConfigLayer{FilePath: filePath, Profile: profile, ConfDir: confDir})
+}
+
+func readConfigFileMap(filePath string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	configType, err := GetConfigTypeFromPath(filePath)
+	if err != nil {
+		configType = ""
+	}
+	fileViper, err := newViperFromByte(data, configType)
+	if err != nil {
+		return nil, errors.New("config file " + filePath + " " + err.Error())
+	}
+	return fileViper.AllSettings(), nil
+}
+
+// getProfileFilePath 在基础配置文件的后缀前插入profile, 覆盖文件不存在时尝试其他支持的后缀
+func getProfileFilePath(filePath string, profile string) string {
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+	profilePath := base + "." + profile + ext
+	if _, err := os.Stat(profilePath); err == nil {
+		return profilePath
+	}
+	for _, configExt := range []string{".toml", ".yaml", ".yml", ".json"} {
+		if _, err := os.Stat(base + "." + profile + configExt); err == nil {
+			return base + "." + profile + configExt
+		}
+	}
+	return profilePath
+}
+
+// getConfDirFileList 按文件名字典序返回目录下所有支持格式的配置文件, 目录不存在时返回空
+func getConfDirFileList(confDir string) ([]string, error) {
+	entryList, err := os.ReadDir(confDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var fileList []string
+	for _, entry := range entryList {
+		if entry.IsDir() {
+			continue
+		}
+		if _, err := GetConfigTypeFromPath(entry.Name()); err != nil {
+			continue
+		}
+		fileList = append(fileList, filepath.Join(confDir, entry.Name()))
+	}
+	return fileList, nil
+}
+
+// mergeConfigMap 将src合并到dst, 规则如下:
+// 两边都是map时递归合并, 键不区分大小写
+// 其他情况(包括切片)由src中的值整体替换dst中的值, 切片不会追加合并
+func mergeConfigMap(dst map[string]interface{}, src map[string]interface{}) {
+	for key, srcVal := range src {
+		key = strings.ToLower(key)
+		srcMap, srcOk := toConfigMap(srcVal)
+		dstMap, dstOk := toConfigMap(dst[key])
+		if srcOk && dstOk {
+			mergeConfigMap(dstMap, srcMap)
+			dst[key] = dstMap
+			continue
+		}
+		dst[key] = srcVal
+	}
+}
+
+func toConfigMap(val interface{}) (map[string]interface{}, bool) {
+	switch m := val.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			if str, ok := k.(string); ok {
+				res[strings.ToLower(str)] = v
+			}
+		}
+		return res, true
+	}
+	return nil, false
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	configCommentTag = "comment"
+	configDefaultTag = "default"
+)
+
+var configBareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type sampleNode struct {
+	name     string
+	comment  string
+	value    reflect.Value
+	children []*sampleNode
+}
+
+// GenerateSampleConfig 根据配置结构体生成带注释的示例配置
+// 字段说明取自comment标签, 默认值取自default标签, 没有default标签时使用config中的当前值
+// json没有注释语法, 字段说明以 "#字段名" 的形式写在字段之前, 读取时会被忽略
+func GenerateSampleConfig(config interface{}, configType string) ([]byte, error) {
+	configType, err := formatConfigType(configType)
+	if err != nil {
+		return nil, err
+	}
+	val := reflect.ValueOf(config)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val = reflect.New(val.Type().Elem())
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, errors.New("config must be a struct or a pointer to struct")
+	}
+	root, err := newSampleNode("", "", val)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	switch configType {
+	case ConfigTypeToml:
+		if err := writeSampleToml(buf, root, ""); err != nil {
+			return nil, err
+		}
+	case ConfigTypeYaml:
+		writeSampleYaml(buf, root, "")
+	case ConfigTypeJson:
+		writeSampleJson(buf, root, "")
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func newSampleNode(name string, comment string, val reflect.Value) (*sampleNode, error) {
+	node := &sampleNode{name: name, comment: comment, value: val}
+	valType := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		field := valType.Field(i)
+		if field.PkgPath != "" || !isConfigKind(field.Type) {
+			continue
+		}
+		fieldName, squash := getConfigFieldName(field)
+		if fieldName == "-" {
+			continue
+		}
+		fieldVal, err := getSampleValue(field, val.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		if isSampleStruct(fieldVal.Type()) {
+			for fieldVal.Kind() == reflect.Ptr {
+				if fieldVal.IsNil() {
+					fieldVal = reflect.New(fieldVal.Type().Elem())
+				}
+				fieldVal = fieldVal.Elem()
+			}
+			child, err := newSampleNode(fieldName, field.Tag.Get(configCommentTag), fieldVal)
+			if err != nil {
+				return nil, err
+			}
+			if squash {
+				node.children = append(node.children, child.children...)
+			} else {
+				node.children = append(node.children, child)
+			}
+			continue
+		}
+		node.children = append(node.children, &sampleNode{name: fieldName,
+			comment: field.Tag.Get(configCommentTag), value: fieldVal})
+	}
+	return node, nil
+}
+
+func getSampleValue(field reflect.StructField, val reflect.Value) (reflect.Value, error) {
+	defaultVal, ok := field.Tag.Lookup(configDefaultTag)
+	if !ok {
+		return val, nil
+	}
+	newVal := reflect.New(field.Type)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           newVal.Interface(),
+	})
+	if err != nil {
+		return val, err
+	}
+	if err := decoder.Decode(defaultVal); err != nil {
+		return val, errors.New("field " + field.Name + " has invalid default value: " + err.Error())
+	}
+	return newVal.Elem(), nil
+}
+
+func isSampleStruct(valType reflect.Type) bool {
+	for valType.Kind() == reflect.Ptr {
+		valType = valType.Elem()
+	}
+	return valType.Kind() == reflect.Struct && valType != reflect.TypeOf(time.Time{})
+}
+
+func (node *sampleNode) isTable() bool {
+	return node.value.Kind() == reflect.Struct && node.value.Type() != reflect.TypeOf(time.Time{})
+}
+
+func writeSampleComment(buf *bytes.Buffer, comment string, indent string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		buf.WriteString(indent + "# " + line + "\n")
+	}
+}
+
+// writeSampleToml map字段写为[table], 以便使用带引号的键, 内联表中的键只能是裸键, 否则返回错误
+func writeSampleToml(buf *bytes.Buffer, node *sampleNode, tablePath string) error {
+	for _, child := range node.children {
+		if child.isTable() || isSampleMap(child.value) {
+			continue
+		}
+		if err := checkSampleTomlValue(child.value); err != nil {
+			return errors.New("field " + child.name + ": " + err.Error())
+		}
+		writeSampleComment(buf, child.comment, "")
+		buf.WriteString(formatSampleKey(child.name) + " = " + formatSampleValue(ConfigTypeToml, child.value) + "\n")
+	}
+	for _, child := range node.children {
+		if !child.isTable() && !isSampleMap(child.value) {
+			continue
+		}
+		childPath := formatSampleKey(child.name)
+		if tablePath != "" {
+			childPath = tablePath + "." + childPath
+		}
+		if buf.Len() != 0 {
+			buf.WriteString("\n")
+		}
+		writeSampleComment(buf, child.comment, "")
+		buf.WriteString("[" + childPath + "]\n")
+		if child.isTable() {
+			if err := writeSampleToml(buf, child, childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		mapVal := getSampleElem(child.value)
+		for _, key := range getSampleMapKeys(mapVal) {
+			if err := checkSampleTomlValue(mapVal.MapIndex(key)); err != nil {
+				return errors.New("field " + child.name + ": " + err.Error())
+			}
+			buf.WriteString(formatSampleKey(fmt.Sprint(key.Interface())) + " = " +
+				formatSampleValue(ConfigTypeToml, mapVal.MapIndex(key)) + "\n")
+		}
+	}
+	return nil
+}
+
+// checkSampleTomlValue go-toml不支持内联表中带引号的键, 内联表中的键不是裸键时返回错误
+func checkSampleTomlValue(val reflect.Value) error {
+	val = getSampleElem(val)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if err := checkSampleTomlValue(val.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			if !configBareKeyRegexp.MatchString(fmt.Sprint(key.Interface())) {
+				return fmt.Errorf("key %q in a toml inline table must be a bare key", fmt.Sprint(key.Interface()))
+			}
+			if err := checkSampleTomlValue(val.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			if val.Type().Field(i).PkgPath == "" {
+				if err := checkSampleTomlValue(val.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writeSampleYaml(buf *bytes.Buffer, node *sampleNode, indent string) {
+	for _, child := range node.children {
+		writeSampleComment(buf, child.comment, indent)
+		if child.isTable() {
+			buf.WriteString(indent + formatSampleKey(child.name) + ":\n")
+			writeSampleYaml(buf, child, indent+"  ")
+			continue
+		}
+		buf.WriteString(indent + formatSampleKey(child.name) + ": " + formatSampleValue(ConfigTypeYaml, child.value) + "\n")
+	}
+}
+
+func writeSampleJson(buf *bytes.Buffer, node *sampleNode, indent string) {
+	var itemList []string
+	childIndent := indent + "  "
+	for _, child := range node.children {
+		if child.comment != "" {
+			itemList = append(itemList, childIndent+quoteSampleString("#"+child.name)+": "+quoteSampleString(child.comment))
+		}
+		if child.isTable() {
+			childBuf := &bytes.Buffer{}
+			writeSampleJson(childBuf, child, childIndent)
+			itemList = append(itemList, childIndent+quoteSampleString(child.name)+": "+childBuf.String())
+			continue
+		}
+		itemList = append(itemList, childIndent+quoteSampleString(child.name)+": "+formatSampleValue(ConfigTypeJson, child.value))
+	}
+	if len(itemList) == 0 {
+		buf.WriteString("{}")
+		return
+	}
+	buf.WriteString("{\n" + strings.Join(itemList, ",\n") + "\n" + indent + "}")
+}
+
+func formatSampleKey(key string) string {
+	if configBareKeyRegexp.MatchString(key) {
+		return key
+	}
+	return quoteSampleString(key)
+}
+
+func formatSampleValue(configType string, val reflect.Value) string {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			if val.Kind() == reflect.Interface {
+				return quoteSampleString("")
+			}
+			val = reflect.New(val.Type().Elem())
+		}
+		val = val.Elem()
+	}
+	switch val.Type() {
+	case reflect.TypeOf(time.Duration(0)):
+		return quoteSampleString(time.Duration(val.Int()).String())
+	case reflect.TypeOf(time.Time{}):
+		return quoteSampleString(val.Interface().(time.Time).Format(time.RFC3339))
+	}
+	switch val.Kind() {
+	case reflect.String:
+		return quoteSampleString(val.String())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(val.Interface())
+	case reflect.Float32, reflect.Float64:
+		str := fmt.Sprint(val.Interface())
+		if !strings.ContainsAny(str, ".eEn") {
+			str += ".0"
+		}
+		return str
+	case reflect.Slice, reflect.Array:
+		itemList := make([]string, 0, val.Len())
+		for i := 0; i < val.Len(); i++ {
+			itemList = append(itemList, formatSampleValue(configType, val.Index(i)))
+		}
+		return "[" + strings.Join(itemList, ", ") + "]"
+	case reflect.Map:
+		keyList := getSampleMapKeys(val)
+		itemList := make([]string, 0, len(keyList))
+		for _, key := range keyList {
+			itemList = append(itemList, formatSampleItem(configType, fmt.Sprint(key.Interface()), val.MapIndex(key)))
+		}
+		return formatSampleTable(configType, itemList)
+	case reflect.Struct:
+		return formatSampleTable(configType, getSampleStructItems(configType, val))
+	}
+	return quoteSampleString(fmt.Sprint(val.Interface()))
+}
+
+// getSampleStructItems 结构体按字段逐个输出, 嵌套的结构体输出为内联表
+func getSampleStructItems(configType string, val reflect.Value) []string {
+	var itemList []string
+	valType := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		field := valType.Field(i)
+		if field.PkgPath != "" || !isConfigKind(field.Type) {
+			continue
+		}
+		name, squash := getConfigFieldName(field)
+		if name == "-" {
+			continue
+		}
+		fieldVal := val.Field(i)
+		if squash && fieldVal.Kind() == reflect.Struct {
+			itemList = append(itemList, getSampleStructItems(configType, fieldVal)...)
+			continue
+		}
+		itemList = append(itemList, formatSampleItem(configType, strings.ToLower(name), fieldVal))
+	}
+	return itemList
+}
+
+// formatSampleItem toml内联表中的键由checkSampleTomlValue保证为裸键
+func formatSampleItem(configType string, key string, val reflect.Value) string {
+	if configType == ConfigTypeToml {
+		return formatSampleKey(key) + " = " + formatSampleValue(configType, val)
+	}
+	return quoteSampleString(key) + ": " + formatSampleValue(configType, val)
+}
+
+func isSampleMap(val reflect.Value) bool {
+	return getSampleElem(val).Kind() == reflect.Map
+}
+
+// getSampleElem 解开指针和接口, nil指针使用零值
+func getSampleElem(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			if val.Kind() == reflect.Interface {
+				return val
+			}
+			val = reflect.New(val.Type().Elem())
+		}
+		val = val.Elem()
+	}
+	return val
+}
+
+func getSampleMapKeys(val reflect.Value) []reflect.Value {
+	keyList := val.MapKeys()
+	sort.Slice(keyList, func(i, j int) bool {
+		return fmt.Sprint(keyList[i].Interface()) < fmt.Sprint(keyList[j].Interface())
+	})
+	return keyList
+}
+
+func formatSampleTable(configType string, itemList []string) string {
+	if len(itemList) == 0 {
+		return "{}"
+	}
+	if configType == ConfigTypeToml {
+		return "{ " + strings.Join(itemList, ", ") + " }"
+	}
+	return "{" + strings.Join(itemList, ", ") + "}"
+}
+
+func quoteSampleString(str string) string {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(str)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type sampleTestItem struct {
+	Name   string
+	Weight int
+	Labels map[string]string
+}
+
+type sampleTestLog struct {
+	Level  string `comment:"日志级别"`
+	Fields map[string]interface{}
+}
+
+type sampleTestConfig struct {
+	Addr    string        `comment:"监听地址" default:"0.0.0.0:8080"`
+	Timeout time.Duration `default:"3s"`
+	Ratio   float64
+	Tags    []string
+	Items   []sampleTestItem
+	Headers map[string]string `comment:"请求头"`
+	Log     sampleTestLog
+}
+
+func newSampleTestConfig() sampleTestConfig {
+	return sampleTestConfig{
+		Ratio: 0.5,
+		Tags:  []string{"a", "b c"},
+		Items: []sampleTestItem{{Name: "x", Weight: 2, Labels: map[string]string{"zone": "z1"}}},
+		Headers: map[string]string{
+			"content-type": "application/json",
+			"a b":          "c",
+		},
+		Log: sampleTestLog{Level: "info", Fields: map[string]interface{}{"svc name": "app", "id": "1"}},
+	}
+}
+
+func TestGenerateSampleConfigRoundTrip(t *testing.T) {
+	want := newSampleTestConfig()
+	want.Addr = "0.0.0.0:8080"
+	want.Timeout = 3 * time.Second
+	for _, configType := range []string{ConfigTypeToml, ConfigTypeYaml, ConfigTypeJson} {
+		data, err := GenerateSampleConfig(newSampleTestConfig(), configType)
+		if err != nil {
+			t.Fatalf("%v: %v", configType, err)
+		}
+		got := sampleTestConfig{}
+		if err := ReadConfigFromByteWithType(&got, data, configType); err != nil {
+			t.Fatalf("%v: %v\n%s", configType, err, data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%v: got %+v, want %+v\n%s", configType, got, want, data)
+		}
+	}
+}
+
+func TestGenerateSampleConfigTomlInlineKey(t *testing.T) {
+	config := newSampleTestConfig()
+	config.Items[0].Labels = map[string]string{"a b": "c"}
+	if _, err := GenerateSampleConfig(config, ConfigTypeToml); err == nil {
+		t.Fatal("toml inline table with a quoted key should return an error")
+	}
+	for _, configType := range []string{ConfigTypeYaml, ConfigTypeJson} {
+		if _, err := GenerateSampleConfig(config, configType); err != nil {
+			t.Fatalf("%v: %v", configType, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"errors"
+	"github.com/liyiligang/base/component/Jdiscovery"
+	"github.com/spf13/viper"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ConfigSecretResolver 根据引用名称获取密钥内容
+type ConfigSecretResolver func(name string) (string, error)
+
+// 配置值中的 ${scheme:name} 会在解析前被替换为对应的密钥内容
+// 内置 ${env:NAME} 读取环境变量, ${file:/run/secrets/x} 读取文件内容(去除末尾换行)
+// ${etcd:/key} 需要先调用 RegisterEtcdSecretResolver
+var configSecretRegexp = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+var configSecretResolverMap sync.Map
+
+func init() {
+	RegisterSecretResolver("env", getEnvSecret)
+	RegisterSecretResolver("file", getFileSecret)
+}
+
+// RegisterSecretResolver 注册密钥引用的解析方式, 相同scheme会覆盖之前的注册
+func RegisterSecretResolver(scheme string, resolver ConfigSecretResolver) {
+	configSecretResolverMap.Store(scheme, resolver)
+}
+
+// RegisterEtcdSecretResolver 使用discovery解析 ${etcd:/key} 形式的密钥引用
+func RegisterEtcdSecretResolver(discovery *Jdiscovery.Discovery) {
+	RegisterSecretResolver("etcd", func(name string) (string, error) {
+		data, err := discovery.GetData(name)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	})
+}
+
+func resolveConfigValue(v *viper.Viper) error {
+	for _, key := range v.AllKeys() {
+		val, changed, err := resolveConfigItem(v.Get(key))
+		if err != nil {
+			return errors.New("config key " + key + " " + err.Error())
+		}
+		if changed {
+			v.Set(key, val)
+		}
+	}
+	return nil
+}
+
+func resolveConfigItem(val interface{}) (interface{}, bool, error) {
+	switch item := val.(type) {
+	case string:
+		str, err := resolveConfigString(item)
+		if err != nil {
+			return nil, false, err
+		}
+		return str, str != item, nil
+	case []interface{}:
+		changed := false
+		itemList := make([]interface{}, len(item))
+		for i, v := range item {
+			newVal, itemChanged, err := resolveConfigItem(v)
+			if err != nil {
+				return nil, false, err
+			}
+			itemList[i] = newVal
+			changed = changed || itemChanged
+		}
+		return itemList, changed, nil
+	case []string:
+		changed := false
+		itemList := make([]string, len(item))
+		for i, v := range item {
+			str, err := resolveConfigString(v)
+			if err != nil {
+				return nil, false, err
+			}
+			itemList[i] = str
+			changed = changed || str != v
+		}
+		return itemList, changed, nil
+	}
+	return val, false, nil
+}
+
+func resolveConfigString(str string) (string, error) {
+	str, err := resolveConfigSecret(str)
+	if err != nil {
+		return "", err
+	}
+	return decryptConfigString(str)
+}
+
+func resolveConfigSecret(str string) (string, error) {
+	if !strings.Contains(str, "${") {
+		return str, nil
+	}
+	var resolveErr error
+	res := configSecretRegexp.ReplaceAllStringFunc(str, func(ref string) string {
+		if resolveErr != nil {
+			return ref
+		}
+		match := configSecretRegexp.FindStringSubmatch(ref)
+		resolver, ok := configSecretResolverMap.Load(match[1])
+		if !ok {
+			resolveErr = errors.New("secret scheme " + match[1] + " is not registered")
+			return ref
+		}
+		secret, err := resolver.(ConfigSecretResolver)(match[2])
+		if err != nil {
+			resolveErr = errors.New("secret " + ref + " resolve failed: " + err.Error())
+			return ref
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return res, nil
+}
+
+func getEnvSecret(name string) (string, error) {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("env " + name + " is not set")
+	}
+	return val, nil
+}
+
+func getFileSecret(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
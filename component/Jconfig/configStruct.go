@@ -0,0 +1,133 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// configField 配置结构体中的一个叶子字段
+// key为viper使用的配置键(小写, 以.分隔), path为结构体字段路径
+// 字段位于值为nil的结构体指针之下时, value为零值, nilParent为true
+type configField struct {
+	key       string
+	path      string
+	field     reflect.StructField
+	value     reflect.Value
+	nilParent bool
+}
+
+func getConfigFields(config interface{}) []configField {
+	val := reflect.ValueOf(config)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+	return appendConfigFields(nil, val, "", "", false)
+}
+
+func appendConfigFields(fields []configField, val reflect.Value, keyPrefix string, pathPrefix string,
+	nilParent bool) []configField {
+	valType := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		field := valType.Field(i)
+		if field.PkgPath != "" || !isConfigKind(field.Type) {
+			continue
+		}
+		name, squash := getConfigFieldName(field)
+		if name == "-" {
+			continue
+		}
+		key := joinConfigKey(keyPrefix, strings.ToLower(name))
+		path := joinConfigKey(pathPrefix, field.Name)
+		fieldVal := val.Field(i)
+		if squash {
+			key, path = keyPrefix, pathPrefix
+		}
+		if isConfigStruct(fieldVal.Type()) {
+			fieldNil := nilParent
+			for fieldVal.Kind() == reflect.Ptr {
+				if fieldVal.IsNil() {
+					fieldVal = reflect.New(fieldVal.Type().Elem())
+					fieldNil = true
+				}
+				fieldVal = fieldVal.Elem()
+			}
+			fields = appendConfigFields(fields, fieldVal, key, path, fieldNil)
+			continue
+		}
+		fields = append(fields, configField{key: key, path: path, field: field, value: fieldVal, nilParent: nilParent})
+	}
+	return fields
+}
+
+func getConfigFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("mapstructure")
+	if tag == "" {
+		return field.Name, false
+	}
+	tagList := strings.Split(tag, ",")
+	squash := false
+	for _, opt := range tagList[1:] {
+		if opt == "squash" {
+			squash = true
+		}
+	}
+	if tagList[0] == "" {
+		return field.Name, squash
+	}
+	return tagList[0], squash
+}
+
+func isConfigKind(fieldType reflect.Type) bool {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return false
+	case reflect.Interface:
+		return fieldType.NumMethod() == 0
+	case reflect.Slice, reflect.Array:
+		return isConfigKind(fieldType.Elem())
+	case reflect.Map:
+		return isConfigKind(fieldType.Key()) && isConfigKind(fieldType.Elem())
+	}
+	return true
+}
+
+// isConfigStruct 结构体和结构体指针按字段展开, 指针为nil时同样展开, 以便环境变量和命令行参数能够设置其中的字段
+func isConfigStruct(valType reflect.Type) bool {
+	for valType.Kind() == reflect.Ptr {
+		valType = valType.Elem()
+	}
+	return valType.Kind() == reflect.Struct && valType != reflect.TypeOf(time.Time{})
+}
+
+func joinConfigKey(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
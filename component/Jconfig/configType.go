@@ -0,0 +1,79 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConfigTypeToml = "toml"
+	ConfigTypeYaml = "yaml"
+	ConfigTypeJson = "json"
+)
+
+// GetConfigTypeFromPath 根据文件后缀获取配置格式
+func GetConfigTypeFromPath(filePath string) (string, error) {
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	if ext == "" {
+		return "", errors.New("config file " + filePath + " has no extension")
+	}
+	return formatConfigType(ext)
+}
+
+// GetConfigTypeFromByte 根据内容判断配置格式, 依次尝试json, toml, yaml
+func GetConfigTypeFromByte(data []byte) (string, error) {
+	trimData := bytes.TrimSpace(data)
+	if len(trimData) == 0 {
+		return "", errors.New("config data is empty")
+	}
+	if (trimData[0] == '{' || trimData[0] == '[') && json.Valid(trimData) {
+		return ConfigTypeJson, nil
+	}
+	for _, configType := range []string{ConfigTypeToml, ConfigTypeYaml} {
+		if isConfigType(data, configType) {
+			return configType, nil
+		}
+	}
+	return "", errors.New("config data format is unknown")
+}
+
+func formatConfigType(configType string) (string, error) {
+	switch strings.ToLower(configType) {
+	case "toml":
+		return ConfigTypeToml, nil
+	case "yaml", "yml":
+		return ConfigTypeYaml, nil
+	case "json":
+		return ConfigTypeJson, nil
+	}
+	return "", errors.New("config type " + configType + " is not supported")
+}
+
+func isConfigType(data []byte, configType string) bool {
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(bytes.NewBuffer(data)); err != nil {
+		return false
+	}
+	return len(v.AllKeys()) != 0
+}
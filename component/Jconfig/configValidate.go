@@ -0,0 +1,190 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 校验规则通过validate标签声明, 多个规则以逗号分隔, 例如 `validate:"required,hostport"`
+// required     值不能为零值, 切片和map长度不能为0
+// min=N, max=N 数值类型比较数值(time.Duration可写作1s), 字符串, 切片和map比较长度
+// oneof=a b c  值必须为列出的其中之一, 以空格分隔
+// file, dir    文件或文件夹必须存在, 值为空时不校验
+// hostport     值必须为host:port格式, 值为空时不校验
+const configValidateTag = "validate"
+
+type ConfigFieldError struct {
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (err *ConfigFieldError) Error() string {
+	return err.Path + " " + err.Message
+}
+
+// ConfigValidateError 汇总了所有未通过校验的字段
+type ConfigValidateError struct {
+	FieldErrors []*ConfigFieldError
+}
+
+func (err *ConfigValidateError) Error() string {
+	errList := make([]string, 0, len(err.FieldErrors))
+	for _, fieldErr := range err.FieldErrors {
+		errList = append(errList, fieldErr.Error())
+	}
+	return "config validate failed: " + strings.Join(errList, "; ")
+}
+
+// ValidateConfig 按validate标签校验配置, 返回的错误类型为*ConfigValidateError
+// ReadConfig*系列函数只负责读取, 需要校验时在读取后调用; ConfigFileWatch和ConfigDiscoveryBind在替换配置前会自动校验
+// 值为nil的结构体指针视为未配置, 不校验其中的字段
+func ValidateConfig(config interface{}) error {
+	var fieldErrList []*ConfigFieldError
+	for _, field := range getConfigFields(config) {
+		tag := field.field.Tag.Get(configValidateTag)
+		if tag == "" || field.nilParent {
+			continue
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			name, param, _ := strings.Cut(rule, "=")
+			if err := validateConfigRule(field.value, name, param); err != nil {
+				fieldErrList = append(fieldErrList, &ConfigFieldError{Path: field.path, Rule: name, Message: err.Error()})
+			}
+		}
+	}
+	if len(fieldErrList) != 0 {
+		return &ConfigValidateError{FieldErrors: fieldErrList}
+	}
+	return nil
+}
+
+func validateConfigRule(val reflect.Value, name string, param string) error {
+	switch name {
+	case "required":
+		if isEmptyConfigValue(val) {
+			return errors.New("is required")
+		}
+	case "min", "max":
+		return validateConfigRange(val, name, param)
+	case "oneof":
+		val = reflect.Indirect(val)
+		if !val.IsValid() {
+			return nil
+		}
+		str := fmt.Sprint(val.Interface())
+		for _, option := range strings.Fields(param) {
+			if str == option {
+				return nil
+			}
+		}
+		return errors.New("must be one of [" + param + "]")
+	case "file", "dir":
+		path, ok := getConfigString(val)
+		if !ok || path == "" {
+			return nil
+		}
+		s, err := os.Stat(path)
+		if err != nil || s.IsDir() != (name == "dir") {
+			return errors.New(name + " " + path + " does not exist")
+		}
+	case "hostport":
+		addr, ok := getConfigString(val)
+		if !ok || addr == "" {
+			return nil
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return errors.New("must be host:port")
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || (n == 0 && port != "0") {
+			return errors.New("has invalid port " + port)
+		}
+	default:
+		return errors.New("has unknown validate rule " + name)
+	}
+	return nil
+}
+
+func validateConfigRange(val reflect.Value, name string, param string) error {
+	val = reflect.Indirect(val)
+	if !val.IsValid() {
+		return nil
+	}
+	var cur, limit float64
+	var err error
+	switch val.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		cur = float64(val.Len())
+		limit, err = strconv.ParseFloat(param, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		cur = float64(val.Int())
+		if val.Type() == reflect.TypeOf(time.Duration(0)) {
+			var d time.Duration
+			d, err = time.ParseDuration(param)
+			limit = float64(d)
+		} else {
+			limit, err = strconv.ParseFloat(param, 64)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cur = float64(val.Uint())
+		limit, err = strconv.ParseFloat(param, 64)
+	case reflect.Float32, reflect.Float64:
+		cur = val.Float()
+		limit, err = strconv.ParseFloat(param, 64)
+	default:
+		return errors.New("does not support rule " + name)
+	}
+	if err != nil {
+		return errors.New("has invalid rule " + name + "=" + param)
+	}
+	if name == "min" && cur < limit {
+		return errors.New("must be at least " + param)
+	}
+	if name == "max" && cur > limit {
+		return errors.New("must be at most " + param)
+	}
+	return nil
+}
+
+func isEmptyConfigValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Slice, reflect.Map:
+		return val.Len() == 0
+	}
+	return val.IsZero()
+}
+
+func getConfigString(val reflect.Value) (string, bool) {
+	val = reflect.Indirect(val)
+	if val.Kind() != reflect.String {
+		return "", false
+	}
+	return val.String(), true
+}
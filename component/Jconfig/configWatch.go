@@ -0,0 +1,148 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jconfig
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const configWatchDelay = 100 * time.Millisecond
+
+// ConfigFileWatch 监听本地配置文件, 文件变化时重新解析为新的T
+// validate标签校验失败或ConfigCheck返回错误时保留旧配置, 并将错误交给ErrorCall
+type ConfigFileWatch[T any] struct {
+	FilePath    string
+	ConfigCall  func(oldConfig *T, newConfig *T)
+	ConfigCheck func(config *T) error
+	ErrorCall   func(err error)
+	config      atomic.Pointer[T]
+	configData  []byte
+	watcher     *fsnotify.Watcher
+	closeOnce   sync.Once
+	closeChan   chan struct{}
+}
+
+// RegisterConfigFileWatch 读取配置文件并开始监听, 首次读取成功后以oldConfig为nil调用ConfigCall
+func RegisterConfigFileWatch[T any](watch *ConfigFileWatch[T]) error {
+	if watch.FilePath == "" {
+		return errors.New("config file path is empty")
+	}
+	if watch.ConfigCall == nil {
+		return errors.New("config call is nil")
+	}
+	if err := watch.reload(); err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(watch.FilePath)); err != nil {
+		watcher.Close()
+		return err
+	}
+	watch.watcher = watcher
+	watch.closeChan = make(chan struct{})
+	go watch.startWatch()
+	return nil
+}
+
+// Get 获取当前生效的配置
+func (watch *ConfigFileWatch[T]) Get() *T {
+	return watch.config.Load()
+}
+
+func (watch *ConfigFileWatch[T]) Close() error {
+	var err error
+	watch.closeOnce.Do(func() {
+		if watch.watcher == nil {
+			return
+		}
+		close(watch.closeChan)
+		err = watch.watcher.Close()
+	})
+	return err
+}
+
+func (watch *ConfigFileWatch[T]) startWatch() {
+	timer := time.NewTimer(configWatchDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-watch.closeChan:
+			return
+		case _, ok := <-watch.watcher.Events:
+			if !ok {
+				return
+			}
+			timer.Reset(configWatchDelay)
+		case err, ok := <-watch.watcher.Errors:
+			if !ok {
+				return
+			}
+			watch.error(err)
+		case <-timer.C:
+			if err := watch.reload(); err != nil {
+				watch.error(err)
+			}
+		}
+	}
+}
+
+func (watch *ConfigFileWatch[T]) reload() error {
+	data, err := os.ReadFile(watch.FilePath)
+	if err != nil {
+		return err
+	}
+	if watch.config.Load() != nil && bytes.Equal(data, watch.configData) {
+		return nil
+	}
+	configType, err := GetConfigTypeFromPath(watch.FilePath)
+	if err != nil {
+		configType = ""
+	}
+	newConfig := new(T)
+	if err := ReadConfigFromByteWithType(newConfig, data, configType); err != nil {
+		return err
+	}
+	if err := ValidateConfig(newConfig); err != nil {
+		return err
+	}
+	if watch.ConfigCheck != nil {
+		if err := watch.ConfigCheck(newConfig); err != nil {
+			return err
+		}
+	}
+	watch.configData = data
+	oldConfig := watch.config.Swap(newConfig)
+	watch.ConfigCall(oldConfig, newConfig)
+	return nil
+}
+
+func (watch *ConfigFileWatch[T]) error(err error) {
+	if watch.ErrorCall != nil {
+		watch.ErrorCall(err)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// DiscoveryWatchResponse 一次监听返回的事件, Revision为返回时的存储版本
+// CompactRevision不为0时表示请求的版本已被压缩, 此时Err为rpctypes.ErrCompacted
+type DiscoveryWatchResponse struct {
+	Events          []*clientv3.Event
+	Revision        int64
+	CompactRevision int64
+	Err             error
+}
+
+// DiscoveryCompare 事务的比较条件, 要求Key的ModRevision等于ModRevision, ModRevision为0时要求Key不存在
+type DiscoveryCompare struct {
+	Key         string
+	ModRevision int64
+}
+
+// DiscoveryOp 事务中的写操作, Delete为true时删除Key, 否则写入Value
+type DiscoveryOp struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// DiscoveryBackend 服务发现的存储后端, 默认为etcd, 测试和离线开发可以使用NewMemoryBackend
+// prefix为true时key作为前缀匹配, revision为0时从当前版本开始监听, Put和Delete返回操作后的存储版本
+// GetKeys与Get相同, 但返回的键不带值
+// Watch返回的通道在ctx取消或后端关闭时关闭, 事件中总是带有PrevKv
+// Txn在所有比较条件成立时原子地执行全部写操作, 返回执行后的存储版本和条件是否成立
+type DiscoveryBackend interface {
+	Get(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error)
+	GetKeys(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error)
+	Put(ctx context.Context, key string, value string, leaseID clientv3.LeaseID) (int64, error)
+	Delete(ctx context.Context, key string, prefix bool) (int64, error)
+	Txn(ctx context.Context, compareList []DiscoveryCompare, opList []DiscoveryOp) (int64, bool, error)
+	Watch(ctx context.Context, key string, prefix bool, revision int64) <-chan DiscoveryWatchResponse
+	Grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error)
+	KeepAlive(ctx context.Context, leaseID clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error)
+	Revoke(ctx context.Context, leaseID clientv3.LeaseID) error
+	Close() error
+}
+
+type etcdBackend struct {
+	client *clientv3.Client
+}
+
+func NewEtcdBackend(client *clientv3.Client) DiscoveryBackend {
+	return &etcdBackend{client: client}
+}
+
+func (backend *etcdBackend) Get(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	var opts []clientv3.OpOption
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := backend.client.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+func (backend *etcdBackend) GetKeys(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	opts := []clientv3.OpOption{clientv3.WithKeysOnly()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := backend.client.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+func (backend *etcdBackend) Put(ctx context.Context, key string, value string, leaseID clientv3.LeaseID) (int64, error) {
+	var opts []clientv3.OpOption
+	if leaseID != clientv3.NoLease {
+		opts = append(opts, clientv3.WithLease(leaseID))
+	}
+	resp, err := backend.client.Put(ctx, key, value, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (backend *etcdBackend) Delete(ctx context.Context, key string, prefix bool) (int64, error) {
+	var opts []clientv3.OpOption
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := backend.client.Delete(ctx, key, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (backend *etcdBackend) Txn(ctx context.Context, compareList []DiscoveryCompare, opList []DiscoveryOp) (int64, bool, error) {
+	cmpList := make([]clientv3.Cmp, 0, len(compareList))
+	for _, compare := range compareList {
+		cmpList = append(cmpList, clientv3.Compare(clientv3.ModRevision(compare.Key), "=", compare.ModRevision))
+	}
+	etcdOpList := make([]clientv3.Op, 0, len(opList))
+	for _, op := range opList {
+		if op.Delete {
+			etcdOpList = append(etcdOpList, clientv3.OpDelete(op.Key))
+		} else {
+			etcdOpList = append(etcdOpList, clientv3.OpPut(op.Key, op.Value))
+		}
+	}
+	resp, err := backend.client.Txn(ctx).If(cmpList...).Then(etcdOpList...).Commit()
+	if err != nil {
+		return 0, false, err
+	}
+	return resp.Header.Revision, resp.Succeeded, nil
+}
+
+func (backend *etcdBackend) Watch(ctx context.Context, key string, prefix bool, revision int64) <-chan DiscoveryWatchResponse {
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	ch := make(chan DiscoveryWatchResponse)
+	go func() {
+		defer close(ch)
+		for res := range backend.client.Watch(ctx, key, opts...) {
+			resp := DiscoveryWatchResponse{Events: res.Events, Revision: res.Header.Revision,
+				CompactRevision: res.CompactRevision, Err: res.Err()}
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (backend *etcdBackend) Grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	resp, err := backend.client.Grant(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return resp.ID, nil
+}
+
+func (backend *etcdBackend) KeepAlive(ctx context.Context, leaseID clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	return backend.client.KeepAlive(ctx, leaseID)
+}
+
+func (backend *etcdBackend) Revoke(ctx context.Context, leaseID clientv3.LeaseID) error {
+	_, err := backend.client.Revoke(ctx, leaseID)
+	return err
+}
+
+func (backend *etcdBackend) Close() error {
+	return backend.client.Close()
+}
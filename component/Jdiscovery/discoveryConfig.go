@@ -17,19 +17,34 @@
 package Jdiscovery
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 type discoveryConfigCall func(oldConfig []byte, newConfig []byte)
+
+// DiscoveryConfig CachePath不为空时, 收到的配置会保存到该文件, ConfigCheck不为空时只保存通过检查的配置
+// 注册时etcd不可用或请求超时则使用缓存的配置启动, 并在连接恢复后重新读取配置, 与缓存不同时再次调用ConfigCall
+// 使用缓存时首次读取的超时为RequestTimeout, 为0时依次使用ConnectTimeout和DefaultConfigCacheTimeout
+// 配置键不存在时直接返回错误, 不使用缓存
 type DiscoveryConfig struct {
 	ConfigKey    string
 	ConfigCall   discoveryConfigCall
+	ConfigCheck  func(data []byte) error
+	CachePath    string
 	configCtx    context.Context
 	configCancel context.CancelFunc
 }
 
+// DefaultConfigCacheTimeout 使用缓存且未设置超时时, 首次读取配置的超时时间, 单位: 秒
+const DefaultConfigCacheTimeout = 5
+
 func (discovery *Discovery) RegisterConfigWatch(config *DiscoveryConfig) error {
 	if config.ConfigKey == "" {
 		return errors.New("config key is empty")
@@ -38,45 +53,156 @@ func (discovery *Discovery) RegisterConfigWatch(config *DiscoveryConfig) error {
 		return errors.New("config call is nil")
 	}
 	config.configCtx, config.configCancel = context.WithCancel(context.Background())
-	data, err := discovery.GetConfig(config.ConfigKey)
+	kvs, revision, err := discovery.getConfigWithCacheTimeout(config)
 	if err != nil {
-		return err
+		if !isUnavailableError(err) {
+			config.configCancel()
+			return err
+		}
+		cacheData, cacheErr := config.readCache()
+		if cacheErr != nil {
+			config.configCancel()
+			return err
+		}
+		config.ConfigCall(nil, cacheData)
+		go discovery.recoverConfigWatch(config, cacheData)
+		discovery.storeConfigWatch(config)
+		return nil
 	}
-	config.ConfigCall(nil, data)
-	go discovery.startConfigWatch(config)
-	discovery.DiscoveryWatchConfigMap[config.ConfigKey] = config
+	config.writeCache(kvs[0].Value)
+	config.ConfigCall(nil, kvs[0].Value)
+	go discovery.startConfigWatch(config, kvs, revision)
+	discovery.storeConfigWatch(config)
 	return nil
 }
 
 func (discovery *Discovery) UnRegisterConfigWatch(configKey string) error {
-	watch, ok := discovery.DiscoveryWatchConfigMap[configKey]
+	watch, ok := discovery.configWatchRegistry.remove(configKey)
 	if !ok {
 		return errors.New("watch config " + configKey + " is not found")
 	}
 	watch.configCancel()
-	delete(discovery.DiscoveryWatchConfigMap, configKey)
 	return nil
 }
 
+// storeConfigWatch 同一个配置键重复注册时取消之前的监听
+func (discovery *Discovery) storeConfigWatch(config *DiscoveryConfig) {
+	if oldConfig, ok := discovery.configWatchRegistry.store(config.ConfigKey, config); ok && oldConfig != config {
+		oldConfig.configCancel()
+	}
+}
+
 func (discovery *Discovery) GetConfig(configKey string) ([]byte, error) {
 	return discovery.GetData(configKey)
 }
 
+// SetConfig 写入配置并记录历史版本, 需要填写修改说明时使用SetConfigWithChange
 func (discovery *Discovery) SetConfig(configKey string, data string) error {
-	return discovery.SetData(configKey, data)
+	_, err := discovery.SetConfigWithChange(configKey, data, DiscoveryConfigChange{})
+	return err
 }
 
-func (discovery *Discovery) startConfigWatch(config *DiscoveryConfig) {
-	discovery.WatchData(config.configCtx, config.ConfigKey, func(ev *clientv3.Event) {
+// startConfigWatch 从读取配置时的版本开始监听, 避免读取和开始监听之间的修改被遗漏
+func (discovery *Discovery) startConfigWatch(config *DiscoveryConfig, kvs []*mvccpb.KeyValue, revision int64) {
+	discovery.newWatcher(config.ConfigKey, false, kvs, revision+1, func(ev *clientv3.Event) {
 		switch ev.Type {
 		case clientv3.EventTypePut:
 			var preData []byte
 			if ev.PrevKv != nil {
 				preData = ev.PrevKv.Value
 			}
+			config.writeCache(ev.Kv.Value)
 			config.ConfigCall(preData, ev.Kv.Value)
 		case clientv3.EventTypeDelete:
-			config.ConfigCall(ev.PrevKv.Value, nil)
+			var preData []byte
+			if ev.PrevKv != nil {
+				preData = ev.PrevKv.Value
+			}
+			config.ConfigCall(preData, nil)
+		}
+	}).run(config.configCtx)
+}
+
+// recoverConfigWatch 以缓存启动后, 按退避间隔重试读取配置, 成功后与缓存对比并开始监听
+// 连接恢复后配置键已被删除时, 以nil调用ConfigCall并继续监听该键
+func (discovery *Discovery) recoverConfigWatch(config *DiscoveryConfig, cacheData []byte) {
+	delay := retryMinDelay
+	for {
+		select {
+		case <-config.configCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+		kvs, revision, err := discovery.getDataWithRevision(config.ConfigKey, false)
+		if err != nil {
+			delay = nextRetryDelay(delay)
+			continue
 		}
-	})
+		if len(kvs) == 0 {
+			config.ConfigCall(cacheData, nil)
+			discovery.startConfigWatch(config, nil, revision)
+			return
+		}
+		if !bytes.Equal(kvs[0].Value, cacheData) {
+			config.writeCache(kvs[0].Value)
+			config.ConfigCall(cacheData, kvs[0].Value)
+		}
+		discovery.startConfigWatch(config, kvs, revision)
+		return
+	}
+}
+
+// getConfigWithCacheTimeout 有缓存可用时限制首次读取的时间, 避免etcd不可用时一直阻塞而无法使用缓存启动
+func (discovery *Discovery) getConfigWithCacheTimeout(config *DiscoveryConfig) ([]*mvccpb.KeyValue, int64, error) {
+	if config.CachePath == "" || discovery.Config.RequestTimeout != 0 {
+		return discovery.getConfigWithRevision(config.ConfigKey)
+	}
+	timeout := discovery.Config.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConfigCacheTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	return discovery.getConfigWithContext(ctx, config.ConfigKey)
+}
+
+func (discovery *Discovery) getConfigWithRevision(configKey string) ([]*mvccpb.KeyValue, int64, error) {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	return discovery.getConfigWithContext(ctx, configKey)
+}
+
+func (discovery *Discovery) getConfigWithContext(ctx context.Context, configKey string) ([]*mvccpb.KeyValue, int64, error) {
+	kvs, revision, err := discovery.Backend.Get(ctx, configKey, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(kvs) == 0 {
+		return nil, 0, errors.New("Key " + configKey + " is not found")
+	}
+	return kvs, revision, nil
+}
+
+func (config *DiscoveryConfig) readCache() ([]byte, error) {
+	if config.CachePath == "" {
+		return nil, errors.New("config cache path is empty")
+	}
+	return os.ReadFile(config.CachePath)
+}
+
+func (config *DiscoveryConfig) writeCache(data []byte) {
+	if config.CachePath == "" {
+		return
+	}
+	if config.ConfigCheck != nil && config.ConfigCheck(data) != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(config.CachePath), 0755); err != nil {
+		return
+	}
+	tmpPath := config.CachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmpPath, config.CachePath)
 }
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type discoveryElectionCall struct {
+	BecomeLeader func()
+	LoseLeader   func()
+}
+
+// DiscoveryElection 基于etcd concurrency的选主, 同一ElectionName下同时只有一个leader
+// ElectionTTL为会话租约时间(秒), 为0时使用60秒, leader进程异常退出后最多ElectionTTL秒后由其他节点接替
+type DiscoveryElection struct {
+	ElectionName   string
+	ElectionValue  string
+	ElectionTTL    int
+	ElectionCall   discoveryElectionCall
+	discovery      *Discovery
+	isLeader       atomic.Bool
+	electionCtx    context.Context
+	electionCancel context.CancelFunc
+	electionDone   chan struct{}
+	resignOnce     sync.Once
+}
+
+// Campaign 开始竞选, 立即返回, 成为leader和失去leader时分别调用BecomeLeader和LoseLeader
+// 会话租约丢失后失去leader并自动重新竞选, 直到调用Resign
+func (discovery *Discovery) Campaign(election *DiscoveryElection) error {
+	if discovery.Client == nil {
+		return errors.New("election requires the etcd backend")
+	}
+	if election.ElectionName == "" {
+		return errors.New("election name is empty")
+	}
+	if election.ElectionTTL <= 0 {
+		election.ElectionTTL = 60
+	}
+	if !discovery.electionRegistry.storeIfAbsent(election.ElectionName, election) {
+		return errors.New("election " + election.ElectionName + " is already campaigning")
+	}
+	election.discovery = discovery
+	election.electionCtx, election.electionCancel = context.WithCancel(context.Background())
+	election.electionDone = make(chan struct{})
+	go discovery.runElection(election)
+	return nil
+}
+
+// Resign 放弃leader并停止竞选, 当前为leader时会调用LoseLeader
+func (election *DiscoveryElection) Resign() {
+	if election.discovery != nil {
+		election.discovery.electionRegistry.removeIf(election.ElectionName, func(entry *DiscoveryElection) bool {
+			return entry == election
+		})
+	}
+	election.resign()
+}
+
+func (election *DiscoveryElection) resign() {
+	election.resignOnce.Do(func() {
+		if election.electionCancel == nil {
+			return
+		}
+		election.electionCancel()
+		<-election.electionDone
+	})
+}
+
+func (election *DiscoveryElection) IsLeader() bool {
+	return election.isLeader.Load()
+}
+
+// GetLeader 获取当前leader的值, 没有leader时返回错误
+func (discovery *Discovery) GetLeader(electionName string) (string, error) {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	kvs, _, err := discovery.Backend.Get(ctx, getElectionPrefix(electionName), true)
+	if err != nil {
+		return "", err
+	}
+	leader := getElectionLeader(kvs)
+	if leader == nil {
+		return "", errors.New("election " + electionName + " has no leader")
+	}
+	return string(leader.Value), nil
+}
+
+// ObserveLeader 监听leader变化, 每次leader变化时发送新leader的值, 没有leader时发送空字符串
+// 通道在ctx取消后关闭
+func (discovery *Discovery) ObserveLeader(ctx context.Context, electionName string) <-chan string {
+	ch := make(chan string, 1)
+	prefix := getElectionPrefix(electionName)
+	go func() {
+		defer close(ch)
+		lastLeader := "\x00"
+		notify := func(kvMap map[string]*mvccpb.KeyValue) {
+			kvs := make([]*mvccpb.KeyValue, 0, len(kvMap))
+			for _, kv := range kvMap {
+				kvs = append(kvs, kv)
+			}
+			leader := ""
+			if kv := getElectionLeader(kvs); kv != nil {
+				leader = string(kv.Value)
+			}
+			if leader == lastLeader {
+				return
+			}
+			lastLeader = leader
+			select {
+			case ch <- leader:
+			case <-ctx.Done():
+			}
+		}
+		for ctx.Err() == nil {
+			reqCtx, cancel := discovery.getRequestContextWithParent(ctx)
+			kvs, revision, err := discovery.Backend.Get(reqCtx, prefix, true)
+			cancel()
+			if err != nil {
+				waitRetryDelay(ctx, retryMinDelay)
+				continue
+			}
+			var watcher *discoveryWatcher
+			watcher = discovery.newWatcher(prefix, true, kvs, revision+1, func(ev *clientv3.Event) {
+				notify(watcher.kvMap)
+			})
+			notify(watcher.kvMap)
+			watcher.run(ctx)
+			return
+		}
+	}()
+	return ch
+}
+
+func (discovery *Discovery) runElection(election *DiscoveryElection) {
+	defer close(election.electionDone)
+	ctx := election.electionCtx
+	delay := retryMinDelay
+	for ctx.Err() == nil {
+		session, err := concurrency.NewSession(discovery.Client, concurrency.WithTTL(election.ElectionTTL),
+			concurrency.WithContext(ctx))
+		if err != nil {
+			waitRetryDelay(ctx, delay)
+			delay = nextRetryDelay(delay)
+			continue
+		}
+		etcdElection := concurrency.NewElection(session, getElectionName(election.ElectionName))
+		if err := etcdElection.Campaign(ctx, election.ElectionValue); err != nil {
+			session.Close()
+			waitRetryDelay(ctx, delay)
+			delay = nextRetryDelay(delay)
+			continue
+		}
+		delay = retryMinDelay
+		election.setLeader(true)
+		select {
+		case <-session.Done():
+		case <-ctx.Done():
+			resignCtx, cancel := discovery.getRequestContext()
+			etcdElection.Resign(resignCtx)
+			cancel()
+		}
+		election.setLeader(false)
+		session.Close()
+	}
+}
+
+func (election *DiscoveryElection) setLeader(isLeader bool) {
+	if election.isLeader.Swap(isLeader) == isLeader {
+		return
+	}
+	if isLeader && election.ElectionCall.BecomeLeader != nil {
+		election.ElectionCall.BecomeLeader()
+	}
+	if !isLeader && election.ElectionCall.LoseLeader != nil {
+		election.ElectionCall.LoseLeader()
+	}
+}
+
+// getElectionName etcd选举在名称后加"/"作为键前缀, 名称本身不带结尾的"/"
+func getElectionName(electionName string) string {
+	return strings.TrimSuffix(electionName, "/")
+}
+
+func getElectionPrefix(electionName string) string {
+	return getElectionName(electionName) + "/"
+}
+
+func getElectionLeader(kvs []*mvccpb.KeyValue) *mvccpb.KeyValue {
+	var leader *mvccpb.KeyValue
+	for _, kv := range kvs {
+		if leader == nil || kv.CreateRevision < leader.CreateRevision {
+			leader = kv
+		}
+	}
+	return leader
+}
+
+func waitRetryDelay(ctx context.Context, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
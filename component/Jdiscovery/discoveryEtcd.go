@@ -0,0 +1,129 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"time"
+)
+
+// newEtcdClient 按DiscoveryInitConfig创建etcd客户端, Namespace不为空时所有键都加上该前缀
+func newEtcdClient(config DiscoveryInitConfig) (*clientv3.Client, error) {
+	etcdConfig, err := getEtcdConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := clientv3.New(etcdConfig)
+	if err != nil {
+		return nil, err
+	}
+	if config.Namespace != "" {
+		client.KV = namespace.NewKV(client.KV, config.Namespace)
+		client.Watcher = namespace.NewWatcher(client.Watcher, config.Namespace)
+		client.Lease = namespace.NewLease(client.Lease, config.Namespace)
+	}
+	return client, nil
+}
+
+func getEtcdConfig(config DiscoveryInitConfig) (clientv3.Config, error) {
+	endpoints := getEtcdEndpoints(config)
+	if len(endpoints) == 0 {
+		return clientv3.Config{}, errors.New("etcd endpoints is empty")
+	}
+	etcdConfig := clientv3.Config{
+		Endpoints:            endpoints,
+		DialTimeout:          time.Duration(config.ConnectTimeout) * time.Second,
+		AutoSyncInterval:     time.Duration(config.AutoSyncInterval) * time.Second,
+		DialKeepAliveTime:    time.Duration(config.KeepAliveTime) * time.Second,
+		DialKeepAliveTimeout: time.Duration(config.KeepAliveTimeout) * time.Second,
+		Username:             config.Username,
+		Password:             config.Password,
+	}
+	tlsConfig, err := getEtcdTLSConfig(config)
+	if err != nil {
+		return clientv3.Config{}, err
+	}
+	etcdConfig.TLS = tlsConfig
+	return etcdConfig, nil
+}
+
+// getEtcdEndpoints 合并EtcdEndpoints和EtcdAddr, EtcdAddr可以是逗号分隔的多个地址
+func getEtcdEndpoints(config DiscoveryInitConfig) []string {
+	var endpoints []string
+	for _, addr := range append(config.EtcdEndpoints, strings.Split(config.EtcdAddr, ",")...) {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			endpoints = append(endpoints, addr)
+		}
+	}
+	return endpoints
+}
+
+// getEtcdTLSConfig 配置了CAPath或证书时启用TLS, 同时配置证书和私钥时使用双向TLS
+func getEtcdTLSConfig(config DiscoveryInitConfig) (*tls.Config, error) {
+	if config.CAPath == "" && config.PublicKeyPath == "" && config.PrivateKeyPath == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{ServerName: config.CertName, MinVersion: tls.VersionTLS12}
+	if config.CAPath != "" {
+		caData, err := os.ReadFile(config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caData) {
+			return nil, errors.New("no valid certificate found in " + config.CAPath)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if config.PublicKeyPath != "" || config.PrivateKeyPath != "" {
+		if config.PublicKeyPath == "" || config.PrivateKeyPath == "" {
+			return nil, errors.New("etcd public key path and private key path must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.PublicKeyPath, config.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// isUnavailableError etcd连接不可用或请求超时
+func isUnavailableError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, clientv3.ErrNoAvailableEndpoints) {
+		return true
+	}
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		return etcdErr.Code() == codes.Unavailable || etcdErr.Code() == codes.DeadlineExceeded
+	}
+	if errStatus, ok := status.FromError(err); ok {
+		return errStatus.Code() == codes.Unavailable || errStatus.Code() == codes.DeadlineExceeded
+	}
+	return false
+}
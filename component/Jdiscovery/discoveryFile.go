@@ -0,0 +1,220 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pelletier/go-toml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fileBackendWatchDelay = 100 * time.Millisecond
+
+// FileBackend 从本地JSON/TOML文件读取节点和配置的存储后端, 用于没有etcd的离线开发
+// 文件中nodes和configs两部分的每一项都以键值写入, 值为字符串时原样写入, 否则编码为json(例如ServiceInstance)
+// 文件变化时把与上次读取的差异作为一次修改写入, 监听和租约等其他行为与MemoryBackend一致, 例如:
+//
+//	[nodes]
+//	"/service/user/1" = { id = "1", name = "user", address = "127.0.0.1", port = 8080 }
+//	[configs]
+//	"/config/app" = "level = 'debug'"
+type FileBackend struct {
+	*MemoryBackend
+	filePath  string
+	errorCall func(err error)
+	fileData  []byte
+	fileMap   map[string]string
+	watcher   *fsnotify.Watcher
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+type discoveryFileData struct {
+	Nodes   map[string]interface{} `json:"nodes"`
+	Configs map[string]interface{} `json:"configs"`
+}
+
+// NewFileBackend 读取filePath并开始监听, 之后文件读取或解析失败时保留已有数据, 并将错误交给errorCall
+func NewFileBackend(filePath string, errorCall func(err error)) (*FileBackend, error) {
+	if filePath == "" {
+		return nil, errors.New("discovery file path is empty")
+	}
+	backend := &FileBackend{MemoryBackend: NewMemoryBackend(), filePath: filePath, errorCall: errorCall,
+		fileMap: make(map[string]string)}
+	if err := backend.reload(); err != nil {
+		backend.MemoryBackend.Close()
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		backend.MemoryBackend.Close()
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(filePath)); err != nil {
+		watcher.Close()
+		backend.MemoryBackend.Close()
+		return nil, err
+	}
+	backend.watcher = watcher
+	backend.closeChan = make(chan struct{})
+	go backend.startWatch()
+	return backend, nil
+}
+
+func (backend *FileBackend) Close() error {
+	backend.closeOnce.Do(func() {
+		close(backend.closeChan)
+		backend.watcher.Close()
+	})
+	return backend.MemoryBackend.Close()
+}
+
+func (backend *FileBackend) startWatch() {
+	timer := time.NewTimer(fileBackendWatchDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-backend.closeChan:
+			return
+		case _, ok := <-backend.watcher.Events:
+			if !ok {
+				return
+			}
+			timer.Reset(fileBackendWatchDelay)
+		case err, ok := <-backend.watcher.Errors:
+			if !ok {
+				return
+			}
+			backend.error(err)
+		case <-timer.C:
+			if err := backend.reload(); err != nil {
+				backend.error(err)
+			}
+		}
+	}
+}
+
+func (backend *FileBackend) reload() error {
+	data, err := os.ReadFile(backend.filePath)
+	if err != nil {
+		return err
+	}
+	if backend.fileData != nil && bytes.Equal(data, backend.fileData) {
+		return nil
+	}
+	fileMap, err := parseDiscoveryFile(backend.filePath, data)
+	if err != nil {
+		return err
+	}
+	var opList []DiscoveryOp
+	for key, value := range fileMap {
+		if oldValue, ok := backend.fileMap[key]; !ok || oldValue != value {
+			opList = append(opList, DiscoveryOp{Key: key, Value: value})
+		}
+	}
+	for key := range backend.fileMap {
+		if _, ok := fileMap[key]; !ok {
+			opList = append(opList, DiscoveryOp{Key: key, Delete: true})
+		}
+	}
+	sort.Slice(opList, func(i, j int) bool {
+		return opList[i].Key < opList[j].Key
+	})
+	if len(opList) != 0 {
+		if _, _, err := backend.MemoryBackend.Txn(context.Background(), nil, opList); err != nil {
+			return err
+		}
+	}
+	backend.fileData = data
+	backend.fileMap = fileMap
+	return nil
+}
+
+func (backend *FileBackend) error(err error) {
+	if backend.errorCall != nil {
+		backend.errorCall(err)
+	}
+}
+
+// parseDiscoveryFile 按扩展名解析, 扩展名不是.json或.toml时依次尝试json和toml
+func parseDiscoveryFile(filePath string, data []byte) (map[string]string, error) {
+	var fileData discoveryFileData
+	var err error
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		err = json.Unmarshal(data, &fileData)
+	case ".toml":
+		err = parseDiscoveryToml(data, &fileData)
+	default:
+		if err = json.Unmarshal(data, &fileData); err != nil {
+			err = parseDiscoveryToml(data, &fileData)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fileMap := make(map[string]string, len(fileData.Nodes)+len(fileData.Configs))
+	for _, valueMap := range []map[string]interface{}{fileData.Nodes, fileData.Configs} {
+		for key, value := range valueMap {
+			if _, ok := fileMap[key]; ok {
+				return nil, errors.New("duplicate key " + key + " in " + filePath)
+			}
+			str, err := formatDiscoveryFileValue(value)
+			if err != nil {
+				return nil, err
+			}
+			fileMap[key] = str
+		}
+	}
+	return fileMap, nil
+}
+
+func parseDiscoveryToml(data []byte, fileData *discoveryFileData) error {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return err
+	}
+	treeMap := tree.ToMap()
+	if nodes, ok := treeMap["nodes"].(map[string]interface{}); ok {
+		fileData.Nodes = nodes
+	}
+	if configs, ok := treeMap["configs"].(map[string]interface{}); ok {
+		fileData.Configs = configs
+	}
+	return nil
+}
+
+func formatDiscoveryFileValue(value interface{}) (string, error) {
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"math/rand"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置历史保存在 ConfigHistoryPrefix/配置键/写入时间 下, 与配置在同一个事务中写入, 版本号为该事务的etcd revision
+// 历史记录使用独立的键保存, 不受etcd压缩影响
+const DefaultConfigHistoryPrefix = "/jdiscovery/history"
+
+// DefaultConfigHistoryLimit ConfigHistoryLimit为0时每个配置键保留的历史版本数
+const DefaultConfigHistoryLimit = 50
+
+// 一次写入最多删除的旧历史数, etcd默认每个事务最多128个操作
+const configHistoryTrimLimit = 16
+
+type DiscoveryConfigAction string
+
+const (
+	DiscoveryConfigSet      DiscoveryConfigAction = "set"
+	DiscoveryConfigRollback DiscoveryConfigAction = "rollback"
+)
+
+// DiscoveryConfigChange 配置修改的说明, Author为空时使用 当前用户@主机名
+type DiscoveryConfigChange struct {
+	Author  string
+	Comment string
+}
+
+// DiscoveryConfigVersion 配置的一个历史版本, RollbackVersion为回滚时的目标版本
+type DiscoveryConfigVersion struct {
+	Version         int64                 `json:"version"`
+	Data            []byte                `json:"data"`
+	Author          string                `json:"author"`
+	Comment         string                `json:"comment"`
+	Action          DiscoveryConfigAction `json:"action"`
+	RollbackVersion int64                 `json:"rollbackVersion,omitempty"`
+	Time            time.Time             `json:"time"`
+}
+
+// SetConfigWithChange 写入配置并记录修改人, 说明和时间, 返回新版本号
+func (discovery *Discovery) SetConfigWithChange(configKey string, data string, change DiscoveryConfigChange) (int64, error) {
+	return discovery.setConfigVersion(configKey, data, nil, &DiscoveryConfigVersion{
+		Author:  change.Author,
+		Comment: change.Comment,
+		Action:  DiscoveryConfigSet,
+	})
+}
+
+// GetConfigHistory 获取配置的历史版本, 按版本号从旧到新排序
+func (discovery *Discovery) GetConfigHistory(configKey string) ([]*DiscoveryConfigVersion, error) {
+	recordList, err := discovery.readConfigHistory(configKey)
+	if err != nil {
+		return nil, err
+	}
+	versionList := make([]*DiscoveryConfigVersion, 0, len(recordList))
+	for _, record := range recordList {
+		versionList = append(versionList, record.version)
+	}
+	return versionList, nil
+}
+
+// GetConfigVersion 获取配置的指定版本
+func (discovery *Discovery) GetConfigVersion(configKey string, version int64) (*DiscoveryConfigVersion, error) {
+	recordList, err := discovery.readConfigHistory(configKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range recordList {
+		if record.version.Version == version {
+			return record.version, nil
+		}
+	}
+	return nil, errors.New("config " + configKey + " version " + strconv.FormatInt(version, 10) + " is not found")
+}
+
+// DiffConfigVersion 按行比较配置的两个版本, 版本号为0时表示当前配置
+// 结果中删除的行以"- "开头, 新增的行以"+ "开头, 未变化的行以"  "开头
+func (discovery *Discovery) DiffConfigVersion(configKey string, oldVersion int64, newVersion int64) (string, error) {
+	oldData, err := discovery.getConfigVersionData(configKey, oldVersion)
+	if err != nil {
+		return "", err
+	}
+	newData, err := discovery.getConfigVersionData(configKey, newVersion)
+	if err != nil {
+		return "", err
+	}
+	return DiffConfig(oldData, newData), nil
+}
+
+// RollbackConfig 将配置回滚到指定版本, 回滚本身作为一个新版本写入, 并照常触发ConfigCall
+func (discovery *Discovery) RollbackConfig(configKey string, version int64, change DiscoveryConfigChange) (int64, error) {
+	configVersion, err := discovery.GetConfigVersion(configKey, version)
+	if err != nil {
+		return 0, err
+	}
+	return discovery.setConfigVersion(configKey, string(configVersion.Data), nil, &DiscoveryConfigVersion{
+		Author:          change.Author,
+		Comment:         change.Comment,
+		Action:          DiscoveryConfigRollback,
+		RollbackVersion: version,
+	})
+}
+
+// DiffConfig 按行比较两份配置
+func DiffConfig(oldData []byte, newData []byte) string {
+	oldLines := splitConfigLines(oldData)
+	newLines := splitConfigLines(newData)
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var builder strings.Builder
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			builder.WriteString("  " + oldLines[i] + "\n")
+			i++
+			j++
+		case j < len(newLines) && (i == len(oldLines) || lcs[i][j+1] > lcs[i+1][j]):
+			builder.WriteString("+ " + newLines[j] + "\n")
+			j++
+		default:
+			builder.WriteString("- " + oldLines[i] + "\n")
+			i++
+		}
+	}
+	return builder.String()
+}
+
+// setConfigVersion 在同一个事务中写入配置和历史, compareList不为空时只在条件成立时写入, 否则返回ErrConfigConflict
+func (discovery *Discovery) setConfigVersion(configKey string, data string, compareList []DiscoveryCompare,
+	configVersion *DiscoveryConfigVersion) (int64, error) {
+	if configVersion.Author == "" {
+		configVersion.Author = getConfigAuthor()
+	}
+	configVersion.Data = []byte(data)
+	configVersion.Time = time.Now().UTC()
+	historyOpList, err := discovery.getConfigHistoryOps(configKey, configVersion, configHistoryTrimLimit)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	opList := append([]DiscoveryOp{{Key: configKey, Value: data}}, historyOpList...)
+	revision, succeeded, err := discovery.Backend.Txn(ctx, compareList, opList)
+	if err != nil {
+		return 0, err
+	}
+	if !succeeded {
+		return 0, ErrConfigConflict
+	}
+	return revision, nil
+}
+
+// getConfigHistoryOps 返回写入一条历史和删除超出数量限制的旧历史的操作, 与配置的写入放在同一个事务中执行
+// 写入前无法得知版本号, 历史以写入时间为键, 版本号在读取时取自历史记录的ModRevision
+// 最多删除maxDelete条旧历史, 超出限制较多时在之后的写入中逐步删除, 避免事务超过etcd的操作数限制
+func (discovery *Discovery) getConfigHistoryOps(configKey string, configVersion *DiscoveryConfigVersion,
+	maxDelete int) ([]DiscoveryOp, error) {
+	history, err := json.Marshal(configVersion)
+	if err != nil {
+		return nil, err
+	}
+	opList := []DiscoveryOp{{Key: discovery.getConfigHistoryKey(configKey), Value: string(history)}}
+	limit := discovery.getConfigHistoryLimit()
+	if limit < 0 || maxDelete <= 0 {
+		return opList, nil
+	}
+	historyPrefix := discovery.getConfigHistoryPrefix(configKey)
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	kvs, _, err := discovery.Backend.GetKeys(ctx, historyPrefix, true)
+	if err != nil {
+		return nil, err
+	}
+	var keyList []*mvccpb.KeyValue
+	for _, kv := range kvs {
+		if !strings.Contains(strings.TrimPrefix(string(kv.Key), historyPrefix), "/") {
+			keyList = append(keyList, kv)
+		}
+	}
+	sort.Slice(keyList, func(i, j int) bool {
+		return keyList[i].ModRevision < keyList[j].ModRevision
+	})
+	for i := 0; i < len(keyList)+1-limit && i < maxDelete; i++ {
+		opList = append(opList, DiscoveryOp{Key: string(keyList[i].Key), Delete: true})
+	}
+	return opList, nil
+}
+
+type configHistoryRecord struct {
+	key     string
+	version *DiscoveryConfigVersion
+}
+
+// readConfigHistory 读取配置的全部历史, 按版本号从旧到新排序
+func (discovery *Discovery) readConfigHistory(configKey string) ([]configHistoryRecord, error) {
+	historyPrefix := discovery.getConfigHistoryPrefix(configKey)
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	kvs, _, err := discovery.Backend.Get(ctx, historyPrefix, true)
+	if err != nil {
+		return nil, err
+	}
+	recordList := make([]configHistoryRecord, 0, len(kvs))
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		if strings.Contains(strings.TrimPrefix(key, historyPrefix), "/") {
+			continue
+		}
+		version := &DiscoveryConfigVersion{}
+		if err := json.Unmarshal(kv.Value, version); err != nil {
+			return nil, fmt.Errorf("config history %v: %w", key, err)
+		}
+		if version.Version == 0 {
+			version.Version = kv.ModRevision
+		}
+		recordList = append(recordList, configHistoryRecord{key: key, version: version})
+	}
+	sort.Slice(recordList, func(i, j int) bool {
+		return recordList[i].version.Version < recordList[j].version.Version
+	})
+	return recordList, nil
+}
+
+func (discovery *Discovery) getConfigVersionData(configKey string, version int64) ([]byte, error) {
+	if version == 0 {
+		return discovery.GetConfig(configKey)
+	}
+	configVersion, err := discovery.GetConfigVersion(configKey, version)
+	if err != nil {
+		return nil, err
+	}
+	return configVersion.Data, nil
+}
+
+func (discovery *Discovery) getConfigHistoryPrefix(configKey string) string {
+	historyPrefix := discovery.Config.ConfigHistoryPrefix
+	if historyPrefix == "" {
+		historyPrefix = DefaultConfigHistoryPrefix
+	}
+	return strings.TrimSuffix(historyPrefix, "/") + "/" + strings.Trim(configKey, "/") + "/"
+}
+
+func (discovery *Discovery) getConfigHistoryKey(configKey string) string {
+	return discovery.getConfigHistoryPrefix(configKey) + fmt.Sprintf("%020d%08x", time.Now().UnixNano(), rand.Uint32())
+}
+
+func (discovery *Discovery) getConfigHistoryLimit() int {
+	if discovery.Config.ConfigHistoryLimit == 0 {
+		return DefaultConfigHistoryLimit
+	}
+	return discovery.Config.ConfigHistoryLimit
+}
+
+func getConfigAuthor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+func splitConfigLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
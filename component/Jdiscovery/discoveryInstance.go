@@ -0,0 +1,260 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ServiceInstanceHealth string
+
+const (
+	ServiceInstanceHealthy   ServiceInstanceHealth = "healthy"
+	ServiceInstanceUnhealthy ServiceInstanceHealth = "unhealthy"
+)
+
+// ServiceInstance 服务实例元数据, 注册在 服务前缀/Name/ID 下
+// json字段顺序固定, Tags排序去重, StartedAt使用UTC, 相同内容的实例编码结果一致
+type ServiceInstance struct {
+	ID        string                `json:"id"`
+	Name      string                `json:"name"`
+	Address   string                `json:"address"`
+	Port      int                   `json:"port"`
+	Version   string                `json:"version"`
+	Weight    int                   `json:"weight"`
+	Zone      string                `json:"zone"`
+	Tags      []string              `json:"tags"`
+	StartedAt time.Time             `json:"startedAt"`
+	Health    ServiceInstanceHealth `json:"health"`
+}
+
+// ServiceInstanceFilter 实例过滤条件, 空字段不参与过滤, Tags要求实例包含全部标签
+type ServiceInstanceFilter struct {
+	Version     string
+	Zone        string
+	Tags        []string
+	HealthyOnly bool
+}
+
+type discoveryWatchServiceInstanceCall struct {
+	InstanceAdd    func(instance *ServiceInstance)
+	InstanceUpdate func(instance *ServiceInstance)
+	InstanceRemove func(instance *ServiceInstance)
+}
+
+// DiscoveryWatchServiceInstance 监听服务的实例元数据, 只回调满足Filter的实例
+// 实例更新后不再满足Filter时调用InstanceRemove, 重新满足时调用InstanceAdd, 无法解析的实例数据被忽略
+type DiscoveryWatchServiceInstance struct {
+	ServicePrefix string
+	ServiceName   string
+	Filter        ServiceInstanceFilter
+	InstanceCall  discoveryWatchServiceInstanceCall
+	watchService  *DiscoveryWatchService
+	instanceLock  sync.RWMutex
+	instanceMap   map[string]*ServiceInstance
+}
+
+// ParseServiceInstance 解析实例数据, Weight小于等于0时视为1
+func ParseServiceInstance(data []byte) (*ServiceInstance, error) {
+	instance := &ServiceInstance{}
+	if err := json.Unmarshal(data, instance); err != nil {
+		return nil, err
+	}
+	if instance.Weight <= 0 {
+		instance.Weight = 1
+	}
+	return instance, nil
+}
+
+// Marshal 编码为稳定的json
+func (instance *ServiceInstance) Marshal() ([]byte, error) {
+	stable := *instance
+	if len(stable.Tags) != 0 {
+		tagMap := make(map[string]struct{}, len(stable.Tags))
+		stable.Tags = make([]string, 0, len(instance.Tags))
+		for _, tag := range instance.Tags {
+			if _, ok := tagMap[tag]; !ok {
+				tagMap[tag] = struct{}{}
+				stable.Tags = append(stable.Tags, tag)
+			}
+		}
+		sort.Strings(stable.Tags)
+	}
+	stable.StartedAt = stable.StartedAt.UTC()
+	return json.Marshal(&stable)
+}
+
+// Endpoint 获取实例地址, Port为0时只返回Address
+func (instance *ServiceInstance) Endpoint() string {
+	if instance.Port == 0 {
+		return instance.Address
+	}
+	return net.JoinHostPort(instance.Address, strconv.Itoa(instance.Port))
+}
+
+func (instance *ServiceInstance) HasTag(tag string) bool {
+	for _, t := range instance.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter *ServiceInstanceFilter) Match(instance *ServiceInstance) bool {
+	if filter.Version != "" && instance.Version != filter.Version {
+		return false
+	}
+	if filter.Zone != "" && instance.Zone != filter.Zone {
+		return false
+	}
+	if filter.HealthyOnly && instance.Health != ServiceInstanceHealthy {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !instance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetServiceNamePrefix 获取服务的实例前缀, 例如 /service + user => /service/user/
+func GetServiceNamePrefix(servicePrefix string, serviceName string) string {
+	return getServicePrefix(servicePrefix) + serviceName + "/"
+}
+
+// RegisterServiceInstance 将实例注册到 服务前缀/Name/ID 下, 由RegisterNode保持租约
+// StartedAt为空时使用当前时间, Health为空时视为healthy
+func (discovery *Discovery) RegisterServiceInstance(servicePrefix string, instance *ServiceInstance, keepLive int64) error {
+	if instance.ID == "" || instance.Name == "" {
+		return errors.New("service instance id or name is empty")
+	}
+	if instance.StartedAt.IsZero() {
+		instance.StartedAt = time.Now()
+	}
+	if instance.Health == "" {
+		instance.Health = ServiceInstanceHealthy
+	}
+	data, err := instance.Marshal()
+	if err != nil {
+		return err
+	}
+	return discovery.RegisterServiceNode(GetServiceNamePrefix(servicePrefix, instance.Name), &DiscoveryNode{
+		NodeKey:      instance.ID,
+		NodeData:     data,
+		NodeKeepLive: keepLive,
+	})
+}
+
+func (discovery *Discovery) UnRegisterServiceInstance(servicePrefix string, serviceName string, instanceID string) error {
+	return discovery.UnRegisterServiceNode(GetServiceNamePrefix(servicePrefix, serviceName), instanceID)
+}
+
+// GetServiceInstances 读取服务当前满足filter的实例, 按ID排序
+func (discovery *Discovery) GetServiceInstances(servicePrefix string, serviceName string,
+	filter ServiceInstanceFilter) ([]*ServiceInstance, error) {
+	dataMap, _, err := discovery.GetDataWithPrefix(GetServiceNamePrefix(servicePrefix, serviceName))
+	if err != nil {
+		return nil, err
+	}
+	instanceList := make([]*ServiceInstance, 0, len(dataMap))
+	for _, data := range dataMap {
+		instance, err := ParseServiceInstance(data)
+		if err != nil || !filter.Match(instance) {
+			continue
+		}
+		instanceList = append(instanceList, instance)
+	}
+	sortServiceInstance(instanceList)
+	return instanceList, nil
+}
+
+func (discovery *Discovery) RegisterServiceInstanceWatch(watchInstance *DiscoveryWatchServiceInstance) error {
+	if watchInstance.ServiceName == "" {
+		return errors.New("service name is empty")
+	}
+	watchInstance.instanceLock.Lock()
+	watchInstance.instanceMap = make(map[string]*ServiceInstance)
+	watchInstance.instanceLock.Unlock()
+	watchInstance.watchService = &DiscoveryWatchService{
+		ServicePrefix: GetServiceNamePrefix(watchInstance.ServicePrefix, watchInstance.ServiceName),
+		ServiceCall: discoveryWatchServiceCall{
+			InstanceAdd:    watchInstance.onInstancePut,
+			InstanceUpdate: watchInstance.onInstancePut,
+			InstanceRemove: watchInstance.onInstanceRemove,
+		},
+	}
+	return discovery.RegisterServiceWatch(watchInstance.watchService)
+}
+
+func (discovery *Discovery) UnRegisterServiceInstanceWatch(servicePrefix string, serviceName string) error {
+	return discovery.UnRegisterServiceWatch(GetServiceNamePrefix(servicePrefix, serviceName))
+}
+
+// GetInstances 获取当前满足Filter的实例, 按ID排序
+func (watchInstance *DiscoveryWatchServiceInstance) GetInstances() []*ServiceInstance {
+	watchInstance.instanceLock.RLock()
+	defer watchInstance.instanceLock.RUnlock()
+	instanceList := make([]*ServiceInstance, 0, len(watchInstance.instanceMap))
+	for _, instance := range watchInstance.instanceMap {
+		instanceList = append(instanceList, instance)
+	}
+	sortServiceInstance(instanceList)
+	return instanceList
+}
+
+func (watchInstance *DiscoveryWatchServiceInstance) onInstancePut(instanceKey string, instanceData []byte) {
+	instance, err := ParseServiceInstance(instanceData)
+	if err != nil || !watchInstance.Filter.Match(instance) {
+		watchInstance.onInstanceRemove(instanceKey, instanceData)
+		return
+	}
+	watchInstance.instanceLock.Lock()
+	_, ok := watchInstance.instanceMap[instanceKey]
+	watchInstance.instanceMap[instanceKey] = instance
+	watchInstance.instanceLock.Unlock()
+	call := watchInstance.InstanceCall.InstanceAdd
+	if ok {
+		call = watchInstance.InstanceCall.InstanceUpdate
+	}
+	if call != nil {
+		call(instance)
+	}
+}
+
+func (watchInstance *DiscoveryWatchServiceInstance) onInstanceRemove(instanceKey string, instanceData []byte) {
+	watchInstance.instanceLock.Lock()
+	instance, ok := watchInstance.instanceMap[instanceKey]
+	delete(watchInstance.instanceMap, instanceKey)
+	watchInstance.instanceLock.Unlock()
+	if ok && watchInstance.InstanceCall.InstanceRemove != nil {
+		watchInstance.InstanceCall.InstanceRemove(instance)
+	}
+}
+
+func sortServiceInstance(instanceList []*ServiceInstance) {
+	sort.Slice(instanceList, func(i, j int) bool {
+		return instanceList[i].ID < instanceList[j].ID
+	})
+}
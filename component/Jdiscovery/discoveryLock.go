@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"strings"
+	"sync"
+)
+
+// ErrLocked TryLock时锁已被其他持有者占用
+var ErrLocked = errors.New("lock is held by another session")
+
+// DiscoveryUnlocker 已获取的分布式锁
+// 锁绑定在一个etcd会话上, 持有者进程退出或会话租约过期(最多ttl秒)后锁自动释放, 此时Done通道关闭
+type DiscoveryUnlocker struct {
+	discovery  *Discovery
+	session    *concurrency.Session
+	mutex      *concurrency.Mutex
+	unlockOnce sync.Once
+	unlockErr  error
+}
+
+// Lock 阻塞直到获取名为name的锁或ctx结束, ttl为会话租约时间(秒), 为0时使用60秒
+// 申请租约使用RequestTimeout, 等待锁的时间只受ctx控制
+func (discovery *Discovery) Lock(ctx context.Context, name string, ttl int) (*DiscoveryUnlocker, error) {
+	unlocker, err := discovery.newUnlocker(ctx, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := unlocker.mutex.Lock(ctx); err != nil {
+		unlocker.session.Close()
+		return nil, err
+	}
+	return unlocker, nil
+}
+
+// TryLock 尝试获取名为name的锁, 锁已被占用时立即返回ErrLocked, 请求受RequestTimeout限制
+func (discovery *Discovery) TryLock(ctx context.Context, name string, ttl int) (*DiscoveryUnlocker, error) {
+	unlocker, err := discovery.newUnlocker(ctx, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+	reqCtx, reqCancel := discovery.getRequestContextWithParent(ctx)
+	defer reqCancel()
+	if err := unlocker.mutex.TryLock(reqCtx); err != nil {
+		unlocker.session.Close()
+		if errors.Is(err, concurrency.ErrLocked) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return unlocker, nil
+}
+
+// Unlock 释放锁并关闭会话, 重复调用返回第一次的结果
+func (unlocker *DiscoveryUnlocker) Unlock() error {
+	unlocker.unlockOnce.Do(func() {
+		ctx, cancel := unlocker.discovery.getRequestContext()
+		defer cancel()
+		unlocker.unlockErr = unlocker.mutex.Unlock(ctx)
+		unlocker.session.Close()
+	})
+	return unlocker.unlockErr
+}
+
+// Done 会话结束(锁已失效)时关闭
+func (unlocker *DiscoveryUnlocker) Done() <-chan struct{} {
+	return unlocker.session.Done()
+}
+
+func (unlocker *DiscoveryUnlocker) Key() string {
+	return unlocker.mutex.Key()
+}
+
+func (discovery *Discovery) newUnlocker(ctx context.Context, name string, ttl int) (*DiscoveryUnlocker, error) {
+	if discovery.Client == nil {
+		return nil, errors.New("lock requires the etcd backend")
+	}
+	if name == "" {
+		return nil, errors.New("lock name is empty")
+	}
+	if ttl <= 0 {
+		ttl = 60
+	}
+	reqCtx, reqCancel := discovery.getRequestContextWithParent(ctx)
+	defer reqCancel()
+	lease, err := discovery.Client.Grant(reqCtx, int64(ttl))
+	if err != nil {
+		return nil, err
+	}
+	session, err := concurrency.NewSession(discovery.Client, concurrency.WithLease(lease.ID))
+	if err != nil {
+		discovery.revokeLease(lease.ID)
+		return nil, err
+	}
+	return &DiscoveryUnlocker{
+		discovery: discovery,
+		session:   session,
+		mutex:     concurrency.NewMutex(session, strings.TrimSuffix(name, "/")),
+	}, nil
+}
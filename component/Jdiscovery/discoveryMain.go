@@ -20,49 +20,111 @@ import (
 	"context"
 	"errors"
 	"go.etcd.io/etcd/client/v3"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 与etcd断开后重试的退避间隔
+const (
+	retryMinDelay = time.Second
+	retryMaxDelay = 30 * time.Second
+)
+
+// DiscoveryInitConfig 时间相关的配置单位均为秒
+//   - EtcdAddr和EtcdEndpoints合并作为etcd集群地址, EtcdAddr可以是逗号分隔的多个地址
+//   - 配置CAPath时使用TLS校验服务端证书, 同时配置PublicKeyPath和PrivateKeyPath时使用双向TLS, CertName为服务端证书名称
+//   - Username和Password不为空时使用etcd用户认证
+//   - AutoSyncInterval不为0时按该间隔从集群同步最新的节点地址
+//   - KeepAliveTime和KeepAliveTimeout为与etcd连接的心跳间隔和心跳超时
+//   - Namespace不为空时, 所有的键(包括选主和分布式锁)都加上该前缀, 仅对etcd后端生效
+//   - ConfigHistoryPrefix为SetConfig记录配置历史的前缀, 为空时使用DefaultConfigHistoryPrefix
+//   - ConfigHistoryLimit为每个配置键保留的历史版本数, 为0时使用DefaultConfigHistoryLimit, 小于0时不限制
 type DiscoveryInitConfig struct {
-	EtcdAddr       string
-	ConnectTimeout int
-	RequestTimeout int
+	EtcdAddr            string
+	EtcdEndpoints       []string
+	ConnectTimeout      int
+	RequestTimeout      int
+	CAPath              string `validate:"file"`
+	PublicKeyPath       string `validate:"file"`
+	PrivateKeyPath      string `validate:"file"`
+	CertName            string
+	Username            string
+	Password            string
+	AutoSyncInterval    int
+	KeepAliveTime       int
+	KeepAliveTimeout    int
+	Namespace           string
+	ConfigHistoryPrefix string
+	ConfigHistoryLimit  int
 }
 
+// Discovery 使用etcd后端时Client为etcd客户端, 使用其他后端时Client为nil
+// 注册的节点, 监听和竞选保存在并发安全的注册表中, 通过List*和Get*查看, Close时统一取消
 type Discovery struct {
-	Client                  *clientv3.Client
-	Config                  DiscoveryInitConfig
-	discoveryNodeMap 		sync.Map
-	DiscoveryWatchConfigMap map[string]*DiscoveryConfig
-	DiscoveryWatchNodeMap   map[string]*DiscoveryWatchNode
+	Client               *clientv3.Client
+	Backend              DiscoveryBackend
+	Config               DiscoveryInitConfig
+	nodeRegistry         discoveryRegistry[*discoveryNodeState]
+	configWatchRegistry  discoveryRegistry[*DiscoveryConfig]
+	nodeWatchRegistry    discoveryRegistry[*DiscoveryWatchNode]
+	serviceWatchRegistry discoveryRegistry[*DiscoveryWatchService]
+	electionRegistry     discoveryRegistry[*DiscoveryElection]
+	closed               atomic.Bool
 }
 
 func DiscoveryInit(config DiscoveryInitConfig) (*Discovery, error) {
-	discovery := Discovery{Config: config}
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{discovery.Config.EtcdAddr},
-		DialTimeout: time.Duration(discovery.Config.ConnectTimeout) * time.Second,
-	})
+	client, err := newEtcdClient(config)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := DiscoveryInitWithBackend(config, NewEtcdBackend(client))
 	if err != nil {
 		return nil, err
 	}
-	discovery.DiscoveryWatchConfigMap = make(map[string]*DiscoveryConfig)
-	discovery.DiscoveryWatchNodeMap = make(map[string]*DiscoveryWatchNode)
 	discovery.Client = client
-	return &discovery, nil
+	return discovery, nil
+}
+
+// DiscoveryInitWithBackend 使用指定的后端初始化, 例如 NewMemoryBackend()
+func DiscoveryInitWithBackend(config DiscoveryInitConfig, backend DiscoveryBackend) (*Discovery, error) {
+	if backend == nil {
+		return nil, errors.New("discovery backend is nil")
+	}
+	return &Discovery{Config: config, Backend: backend}, nil
 }
 
-func (discovery *Discovery) getRequestContext() context.Context {
-	ctx := context.Background()
+func (discovery *Discovery) getRequestContext() (context.Context, context.CancelFunc) {
+	return discovery.getRequestContextWithParent(context.Background())
+}
+
+func (discovery *Discovery) getRequestContextWithParent(parent context.Context) (context.Context, context.CancelFunc) {
 	if discovery.Config.RequestTimeout != 0 {
-		ctx, _ = context.WithTimeout(ctx, time.Duration(discovery.Config.RequestTimeout)*time.Second)
+		return context.WithTimeout(parent, time.Duration(discovery.Config.RequestTimeout)*time.Second)
 	}
-	return ctx
+	return context.WithCancel(parent)
 }
 
+// SetData opts只在使用etcd后端时有效, 使用其它后端时传入opts返回错误
 func (discovery *Discovery) SetData(key string, data string, opts ...clientv3.OpOption) error {
-	_, err := discovery.Client.Put(discovery.getRequestContext(), key, data, opts...)
+	if len(opts) == 0 {
+		return discovery.SetDataWithLease(key, data, clientv3.NoLease)
+	}
+	if discovery.Client == nil {
+		return errors.New("put options are only supported by the etcd backend")
+	}
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	_, err := discovery.Client.Put(ctx, key, data, opts...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (discovery *Discovery) SetDataWithLease(key string, data string, leaseID clientv3.LeaseID) error {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	_, err := discovery.Backend.Put(ctx, key, data, leaseID)
 	if err != nil {
 		return err
 	}
@@ -70,23 +132,56 @@ func (discovery *Discovery) SetData(key string, data string, opts ...clientv3.Op
 }
 
 func (discovery *Discovery) GetData(key string) ([]byte, error) {
-	resp, err := discovery.Client.Get(discovery.getRequestContext(), key)
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	kvs, _, err := discovery.Backend.Get(ctx, key, false)
 	if err != nil {
 		return nil, err
 	}
-	if resp.Kvs == nil {
+	if len(kvs) == 0 {
 		return nil, errors.New("Key " + key + " is not found")
 	}
-	return resp.Kvs[0].Value, nil
+	return kvs[0].Value, nil
+}
+
+// GetDataWithPrefix 获取前缀下的所有键值, 同时返回读取时的存储版本
+func (discovery *Discovery) GetDataWithPrefix(prefix string) (map[string][]byte, int64, error) {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	kvs, revision, err := discovery.Backend.Get(ctx, prefix, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataMap := make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		dataMap[string(kv.Key)] = kv.Value
+	}
+	return dataMap, revision, nil
+}
+
+func (discovery *Discovery) DelData(key string) error {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	_, err := discovery.Backend.Delete(ctx, key, false)
+	return err
 }
 
+// WatchData 从当前版本开始监听key, 监听中断后从最后收到的版本继续, 直到ctx取消
+// 历史版本已被压缩时重新读取并以合成事件回调变化
 func (discovery *Discovery) WatchData(ctx context.Context, key string, call func(e *clientv3.Event)) {
-	for {
-		watch := discovery.Client.Watch(ctx, key, clientv3.WithPrevKV())
-		for res := range watch {
-			for _, ev := range res.Events {
-				call(ev)
-			}
-		}
+	discovery.newWatcher(key, false, nil, 0, call).run(ctx)
+}
+
+// WatchDataWithPrefix 从revision开始监听前缀下的所有键, revision为0时从当前版本开始
+// 监听中断后从最后收到的版本继续, 直到ctx取消
+func (discovery *Discovery) WatchDataWithPrefix(ctx context.Context, prefix string, revision int64, call func(e *clientv3.Event)) {
+	discovery.newWatcher(prefix, true, nil, revision, call).run(ctx)
+}
+
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
 	}
+	return delay
 }
@@ -0,0 +1,470 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内存后端最多保留的历史事件数, 更早的版本视为已压缩
+const memoryHistoryLimit = 1000
+
+// MemoryBackend 基于内存的DiscoveryBackend, 与etcd一样支持版本号, 带版本的监听和租约过期
+type MemoryBackend struct {
+	lock            sync.Mutex
+	revision        int64
+	compactRevision int64
+	leaseID         clientv3.LeaseID
+	kvMap           map[string]*mvccpb.KeyValue
+	leaseMap        map[clientv3.LeaseID]*memoryLease
+	watcherMap      map[*memoryWatcher]struct{}
+	history         []*clientv3.Event
+	closed          bool
+}
+
+type memoryLease struct {
+	id      clientv3.LeaseID
+	ttl     int64
+	keyMap  map[string]struct{}
+	timer   *time.Timer
+	revoked chan struct{}
+}
+
+type memoryWatcher struct {
+	key    string
+	prefix bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    chan DiscoveryWatchResponse
+	lock   sync.Mutex
+	queue  []DiscoveryWatchResponse
+	notify chan struct{}
+	done   bool
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		revision:   1,
+		kvMap:      make(map[string]*mvccpb.KeyValue),
+		leaseMap:   make(map[clientv3.LeaseID]*memoryLease),
+		watcherMap: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+func (backend *MemoryBackend) Get(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil, 0, errors.New("memory backend is closed")
+	}
+	var kvList []*mvccpb.KeyValue
+	for _, kv := range backend.kvMap {
+		if matchMemoryKey(string(kv.Key), key, prefix) {
+			kvList = append(kvList, copyMemoryKeyValue(kv))
+		}
+	}
+	sort.Slice(kvList, func(i, j int) bool {
+		return string(kvList[i].Key) < string(kvList[j].Key)
+	})
+	return kvList, backend.revision, nil
+}
+
+func (backend *MemoryBackend) GetKeys(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	kvList, revision, err := backend.Get(ctx, key, prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, kv := range kvList {
+		kv.Value = nil
+	}
+	return kvList, revision, nil
+}
+
+func (backend *MemoryBackend) Put(ctx context.Context, key string, value string, leaseID clientv3.LeaseID) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return 0, errors.New("memory backend is closed")
+	}
+	if leaseID != clientv3.NoLease {
+		if _, ok := backend.leaseMap[leaseID]; !ok {
+			return 0, rpctypes.ErrLeaseNotFound
+		}
+	}
+	backend.revision++
+	backend.publish([]*clientv3.Event{backend.putKey(key, value, leaseID)})
+	return backend.revision, nil
+}
+
+func (backend *MemoryBackend) Delete(ctx context.Context, key string, prefix bool) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return 0, errors.New("memory backend is closed")
+	}
+	var keyList []string
+	for k := range backend.kvMap {
+		if matchMemoryKey(k, key, prefix) {
+			keyList = append(keyList, k)
+		}
+	}
+	if len(keyList) == 0 {
+		return backend.revision, nil
+	}
+	sort.Strings(keyList)
+	backend.revision++
+	var events []*clientv3.Event
+	for _, k := range keyList {
+		events = append(events, backend.deleteKey(k))
+	}
+	backend.publish(events)
+	return backend.revision, nil
+}
+
+func (backend *MemoryBackend) Txn(ctx context.Context, compareList []DiscoveryCompare, opList []DiscoveryOp) (int64, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return 0, false, errors.New("memory backend is closed")
+	}
+	for _, compare := range compareList {
+		var modRevision int64
+		if kv, ok := backend.kvMap[compare.Key]; ok {
+			modRevision = kv.ModRevision
+		}
+		if modRevision != compare.ModRevision {
+			return backend.revision, false, nil
+		}
+	}
+	if len(opList) == 0 {
+		return backend.revision, true, nil
+	}
+	backend.revision++
+	var events []*clientv3.Event
+	for _, op := range opList {
+		if !op.Delete {
+			events = append(events, backend.putKey(op.Key, op.Value, clientv3.NoLease))
+		} else if _, ok := backend.kvMap[op.Key]; ok {
+			events = append(events, backend.deleteKey(op.Key))
+		}
+	}
+	backend.publish(events)
+	return backend.revision, true, nil
+}
+
+func (backend *MemoryBackend) Watch(ctx context.Context, key string, prefix bool, revision int64) <-chan DiscoveryWatchResponse {
+	watcher := &memoryWatcher{key: key, prefix: prefix, out: make(chan DiscoveryWatchResponse), notify: make(chan struct{}, 1)}
+	watcher.ctx, watcher.cancel = context.WithCancel(ctx)
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		watcher.cancel()
+	} else if revision > 0 && revision < backend.compactRevision {
+		watcher.done = true
+		watcher.push(DiscoveryWatchResponse{Revision: backend.revision, CompactRevision: backend.compactRevision,
+			Err: rpctypes.ErrCompacted})
+	} else {
+		if revision > 0 {
+			var events []*clientv3.Event
+			for _, ev := range backend.history {
+				if ev.Kv.ModRevision >= revision && watcher.match(string(ev.Kv.Key)) {
+					events = append(events, ev)
+				}
+			}
+			if len(events) != 0 {
+				watcher.push(DiscoveryWatchResponse{Events: events, Revision: backend.revision})
+			}
+		}
+		backend.watcherMap[watcher] = struct{}{}
+		go func() {
+			<-watcher.ctx.Done()
+			backend.lock.Lock()
+			delete(backend.watcherMap, watcher)
+			backend.lock.Unlock()
+		}()
+	}
+	go watcher.run()
+	return watcher.out
+}
+
+func (backend *MemoryBackend) Grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	if err := ctx.Err(); err != nil {
+		return clientv3.NoLease, err
+	}
+	if ttl <= 0 {
+		return clientv3.NoLease, errors.New("lease ttl must be more than 0")
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return clientv3.NoLease, errors.New("memory backend is closed")
+	}
+	backend.leaseID++
+	lease := &memoryLease{id: backend.leaseID, ttl: ttl, keyMap: make(map[string]struct{}), revoked: make(chan struct{})}
+	lease.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
+		backend.lock.Lock()
+		defer backend.lock.Unlock()
+		backend.revokeLease(lease.id)
+	})
+	backend.leaseMap[lease.id] = lease
+	return lease.id, nil
+}
+
+func (backend *MemoryBackend) KeepAlive(ctx context.Context, leaseID clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	backend.lock.Lock()
+	lease, ok := backend.leaseMap[leaseID]
+	backend.lock.Unlock()
+	if !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 16)
+	interval := time.Duration(lease.ttl) * time.Second / 3
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if !backend.refreshLease(lease) {
+				return
+			}
+			select {
+			case ch <- &clientv3.LeaseKeepAliveResponse{ID: lease.id, TTL: lease.ttl}:
+			default:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-lease.revoked:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (backend *MemoryBackend) Revoke(ctx context.Context, leaseID clientv3.LeaseID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if _, ok := backend.leaseMap[leaseID]; !ok {
+		return rpctypes.ErrLeaseNotFound
+	}
+	backend.revokeLease(leaseID)
+	return nil
+}
+
+// Compact 压缩revision之前的历史事件, 之后从更早的版本开始监听将返回rpctypes.ErrCompacted
+func (backend *MemoryBackend) Compact(revision int64) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	backend.compact(revision)
+}
+
+func (backend *MemoryBackend) Close() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if backend.closed {
+		return nil
+	}
+	backend.closed = true
+	for id, lease := range backend.leaseMap {
+		lease.timer.Stop()
+		close(lease.revoked)
+		delete(backend.leaseMap, id)
+	}
+	for watcher := range backend.watcherMap {
+		watcher.cancel()
+		delete(backend.watcherMap, watcher)
+	}
+	return nil
+}
+
+func (backend *MemoryBackend) putKey(key string, value string, leaseID clientv3.LeaseID) *clientv3.Event {
+	prevKv, ok := backend.kvMap[key]
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: backend.revision,
+		ModRevision: backend.revision, Version: 1, Lease: int64(leaseID)}
+	if ok {
+		kv.CreateRevision = prevKv.CreateRevision
+		kv.Version = prevKv.Version + 1
+		if lease, ok := backend.leaseMap[clientv3.LeaseID(prevKv.Lease)]; ok {
+			delete(lease.keyMap, key)
+		}
+	}
+	if lease, ok := backend.leaseMap[leaseID]; ok {
+		lease.keyMap[key] = struct{}{}
+	}
+	backend.kvMap[key] = kv
+	ev := &clientv3.Event{Type: clientv3.EventTypePut, Kv: copyMemoryKeyValue(kv)}
+	if ok {
+		ev.PrevKv = prevKv
+	}
+	return ev
+}
+
+func (backend *MemoryBackend) deleteKey(key string) *clientv3.Event {
+	prevKv := backend.kvMap[key]
+	delete(backend.kvMap, key)
+	if lease, ok := backend.leaseMap[clientv3.LeaseID(prevKv.Lease)]; ok {
+		delete(lease.keyMap, key)
+	}
+	return &clientv3.Event{Type: clientv3.EventTypeDelete,
+		Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: backend.revision}, PrevKv: prevKv}
+}
+
+func (backend *MemoryBackend) revokeLease(leaseID clientv3.LeaseID) {
+	lease, ok := backend.leaseMap[leaseID]
+	if !ok {
+		return
+	}
+	lease.timer.Stop()
+	close(lease.revoked)
+	delete(backend.leaseMap, leaseID)
+	if len(lease.keyMap) == 0 {
+		return
+	}
+	keyList := make([]string, 0, len(lease.keyMap))
+	for key := range lease.keyMap {
+		keyList = append(keyList, key)
+	}
+	sort.Strings(keyList)
+	backend.revision++
+	var events []*clientv3.Event
+	for _, key := range keyList {
+		events = append(events, backend.deleteKey(key))
+	}
+	backend.publish(events)
+}
+
+func (backend *MemoryBackend) refreshLease(lease *memoryLease) bool {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if _, ok := backend.leaseMap[lease.id]; !ok {
+		return false
+	}
+	lease.timer.Reset(time.Duration(lease.ttl) * time.Second)
+	return true
+}
+
+func (backend *MemoryBackend) publish(events []*clientv3.Event) {
+	backend.history = append(backend.history, events...)
+	if len(backend.history) > memoryHistoryLimit {
+		backend.compact(backend.history[len(backend.history)-memoryHistoryLimit].Kv.ModRevision)
+	}
+	for watcher := range backend.watcherMap {
+		var matchEvents []*clientv3.Event
+		for _, ev := range events {
+			if watcher.match(string(ev.Kv.Key)) {
+				matchEvents = append(matchEvents, ev)
+			}
+		}
+		if len(matchEvents) != 0 {
+			watcher.push(DiscoveryWatchResponse{Events: matchEvents, Revision: backend.revision})
+		}
+	}
+}
+
+func (backend *MemoryBackend) compact(revision int64) {
+	if revision <= backend.compactRevision {
+		return
+	}
+	if revision > backend.revision {
+		revision = backend.revision
+	}
+	backend.compactRevision = revision
+	pos := sort.Search(len(backend.history), func(i int) bool {
+		return backend.history[i].Kv.ModRevision >= revision
+	})
+	backend.history = append([]*clientv3.Event(nil), backend.history[pos:]...)
+}
+
+func (watcher *memoryWatcher) match(key string) bool {
+	return matchMemoryKey(key, watcher.key, watcher.prefix)
+}
+
+func (watcher *memoryWatcher) push(resp DiscoveryWatchResponse) {
+	watcher.lock.Lock()
+	watcher.queue = append(watcher.queue, resp)
+	watcher.lock.Unlock()
+	select {
+	case watcher.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (watcher *memoryWatcher) run() {
+	defer close(watcher.out)
+	defer watcher.cancel()
+	for {
+		watcher.lock.Lock()
+		if len(watcher.queue) == 0 {
+			done := watcher.done
+			watcher.lock.Unlock()
+			if done {
+				return
+			}
+			select {
+			case <-watcher.ctx.Done():
+				return
+			case <-watcher.notify:
+				continue
+			}
+		}
+		resp := watcher.queue[0]
+		watcher.queue = watcher.queue[1:]
+		watcher.lock.Unlock()
+		select {
+		case watcher.out <- resp:
+		case <-watcher.ctx.Done():
+			return
+		}
+	}
+}
+
+func matchMemoryKey(key string, watchKey string, prefix bool) bool {
+	if prefix {
+		return strings.HasPrefix(key, watchKey)
+	}
+	return key == watchKey
+}
+
+func copyMemoryKeyValue(kv *mvccpb.KeyValue) *mvccpb.KeyValue {
+	newKv := *kv
+	return &newKv
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const memoryTestTimeout = 5 * time.Second
+
+func newMemoryDiscovery(t *testing.T) (*Discovery, *MemoryBackend) {
+	backend := NewMemoryBackend()
+	discovery, err := DiscoveryInitWithBackend(DiscoveryInitConfig{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		discovery.Close(context.Background())
+	})
+	return discovery, backend
+}
+
+func putMemoryData(t *testing.T, backend *MemoryBackend, key string, value string) int64 {
+	revision, err := backend.Put(context.Background(), key, value, clientv3.NoLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revision
+}
+
+func readWatchEvent(t *testing.T, ch <-chan *clientv3.Event) *clientv3.Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(memoryTestTimeout):
+		t.Fatal("wait watch event timeout")
+	}
+	return nil
+}
+
+func TestMemoryBackendWatchResume(t *testing.T) {
+	discovery, backend := newMemoryDiscovery(t)
+	putMemoryData(t, backend, "/test/a", "1")
+	revision := putMemoryData(t, backend, "/test/a", "2")
+	putMemoryData(t, backend, "/test/b", "3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *clientv3.Event, 16)
+	go discovery.WatchDataWithPrefix(ctx, "/test/", revision, func(ev *clientv3.Event) {
+		ch <- ev
+	})
+
+	ev := readWatchEvent(t, ch)
+	if string(ev.Kv.Key) != "/test/a" || string(ev.Kv.Value) != "2" || ev.Kv.ModRevision != revision {
+		t.Fatalf("unexpected first event: %v", ev.Kv)
+	}
+	if ev.PrevKv == nil || string(ev.PrevKv.Value) != "1" {
+		t.Fatalf("first event should carry the previous value: %v", ev.PrevKv)
+	}
+	ev = readWatchEvent(t, ch)
+	if string(ev.Kv.Key) != "/test/b" || string(ev.Kv.Value) != "3" {
+		t.Fatalf("unexpected second event: %v", ev.Kv)
+	}
+
+	if _, err := backend.Delete(context.Background(), "/test/a", false); err != nil {
+		t.Fatal(err)
+	}
+	ev = readWatchEvent(t, ch)
+	if ev.Type != clientv3.EventTypeDelete || string(ev.Kv.Key) != "/test/a" {
+		t.Fatalf("unexpected delete event: %v", ev)
+	}
+}
+
+func TestMemoryBackendSharedWatch(t *testing.T) {
+	discovery, backend := newMemoryDiscovery(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const putCount = 50
+	chList := make([]chan *clientv3.Event, 2)
+	for i := range chList {
+		ch := make(chan *clientv3.Event, putCount)
+		chList[i] = ch
+		go discovery.WatchDataWithPrefix(ctx, "/svc/", 0, func(ev *clientv3.Event) {
+			ch <- ev
+		})
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < putCount; i++ {
+		putMemoryData(t, backend, "/svc/a", strconv.Itoa(i))
+	}
+	for _, ch := range chList {
+		for i := 0; i < putCount; i++ {
+			ev := readWatchEvent(t, ch)
+			if string(ev.Kv.Value) != strconv.Itoa(i) {
+				t.Fatalf("unexpected event value: %v", ev.Kv)
+			}
+			if i > 0 && (ev.PrevKv == nil || string(ev.PrevKv.Value) != strconv.Itoa(i-1)) {
+				t.Fatalf("unexpected previous value: %v", ev.PrevKv)
+			}
+		}
+	}
+}
+
+func TestMemoryBackendCompact(t *testing.T) {
+	discovery, backend := newMemoryDiscovery(t)
+	oldRevision := putMemoryData(t, backend, "/test/a", "1")
+	putMemoryData(t, backend, "/test/b", "2")
+	revision := putMemoryData(t, backend, "/test/a", "3")
+	backend.Compact(revision)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, ok := <-backend.Watch(ctx, "/test/", true, oldRevision)
+	if !ok {
+		t.Fatal("watch channel closed without compact response")
+	}
+	if !errors.Is(resp.Err, rpctypes.ErrCompacted) || resp.CompactRevision != revision {
+		t.Fatalf("unexpected compact response: %+v", resp)
+	}
+	resp, ok = <-backend.Watch(ctx, "/test/", true, revision)
+	if !ok || resp.Err != nil || len(resp.Events) != 1 || string(resp.Events[0].Kv.Value) != "3" {
+		t.Fatalf("watch from the compact revision should return its events: %+v", resp)
+	}
+
+	ch := make(chan *clientv3.Event, 16)
+	go discovery.WatchDataWithPrefix(ctx, "/test/", oldRevision, func(ev *clientv3.Event) {
+		ch <- ev
+	})
+	valueMap := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		ev := readWatchEvent(t, ch)
+		if ev.Type != clientv3.EventTypePut {
+			t.Fatalf("unexpected resync event: %v", ev)
+		}
+		valueMap[string(ev.Kv.Key)] = string(ev.Kv.Value)
+	}
+	if valueMap["/test/a"] != "3" || valueMap["/test/b"] != "2" {
+		t.Fatalf("unexpected resync values: %v", valueMap)
+	}
+
+	putMemoryData(t, backend, "/test/b", "4")
+	ev := readWatchEvent(t, ch)
+	if string(ev.Kv.Key) != "/test/b" || string(ev.Kv.Value) != "4" {
+		t.Fatalf("watch should continue after resync: %v", ev.Kv)
+	}
+}
+
+func TestMemoryBackendLeaseExpire(t *testing.T) {
+	discovery, backend := newMemoryDiscovery(t)
+	leaseID, err := backend.Grant(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := discovery.SetDataWithLease("/test/lease", "1", leaseID); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *clientv3.Event, 16)
+	go discovery.WatchData(ctx, "/test/lease", func(ev *clientv3.Event) {
+		ch <- ev
+	})
+
+	ev := readWatchEvent(t, ch)
+	if ev.Type != clientv3.EventTypeDelete || string(ev.Kv.Key) != "/test/lease" {
+		t.Fatalf("unexpected lease expire event: %v", ev)
+	}
+	if ev.PrevKv == nil || string(ev.PrevKv.Value) != "1" {
+		t.Fatalf("lease expire event should carry the previous value: %v", ev.PrevKv)
+	}
+	if _, err := discovery.GetData("/test/lease"); err == nil {
+		t.Fatal("key should be deleted after lease expired")
+	}
+	if err := backend.Revoke(context.Background(), leaseID); !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		t.Fatalf("expired lease should not be found: %v", err)
+	}
+}
+
+func TestMemoryBackendSetDataWithOption(t *testing.T) {
+	discovery, _ := newMemoryDiscovery(t)
+	if err := discovery.SetData("/test/a", "1", clientv3.WithPrevKV()); err == nil {
+		t.Fatal("put options should be rejected by the memory backend")
+	}
+	if err := discovery.SetData("/test/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+}
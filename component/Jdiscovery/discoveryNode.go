@@ -19,13 +19,43 @@ package Jdiscovery
 import (
 	"context"
 	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DiscoveryNodeEvent 注册节点的租约状态变化
+type DiscoveryNodeEvent int
+
+const (
+	DiscoveryNodeLeaseLost DiscoveryNodeEvent = iota + 1
+	DiscoveryNodeReRegistered
+	DiscoveryNodeReRegisterFailed
+	DiscoveryNodeUnhealthy
+	DiscoveryNodeRecovered
+)
+
+const (
+	defaultNodeHealthInterval  = 10
+	defaultNodeHealthThreshold = 3
+)
+
+var errNodeUnhealthy = errors.New("node is unhealthy")
+
+// DiscoveryNode 注册后会持续监控租约, 租约丢失时按退避间隔重新申请租约并写入节点数据
+// NodeEventCall不为空时通知租约丢失, 重新注册成功和重新注册失败
+// NodeHealthCheck不为空时, 注册前和之后每隔NodeHealthInterval秒(默认10秒)检查一次, 节点只在检查通过时注册:
+// 连续NodeHealthThreshold次(默认3次)失败后注销节点并通知DiscoveryNodeUnhealthy, 再次通过后重新注册并通知DiscoveryNodeRecovered
 type DiscoveryNode struct {
-	NodeKey      string
-	NodeData     []byte
-	NodeKeepLive int64
+	NodeKey             string
+	NodeData            []byte
+	NodeKeepLive        int64
+	NodeEventCall       func(nodeKey string, event DiscoveryNodeEvent, err error)
+	NodeHealthCheck     func() error
+	NodeHealthInterval  int
+	NodeHealthThreshold int
 }
 
 type discoveryWatchNodeCall struct {
@@ -41,101 +71,290 @@ type DiscoveryWatchNode struct {
 	nodeCancel context.CancelFunc
 }
 
+// discoveryNodeState generation在节点每次因健康检查失败被注销时增加, 旧的重新注册流程据此退出
+type discoveryNodeState struct {
+	node        DiscoveryNode
+	leaseID     atomic.Int64
+	nodeCtx     context.Context
+	nodeCancel  context.CancelFunc
+	lock        sync.Mutex
+	leaseCancel context.CancelFunc
+	unhealthy   bool
+	generation  int64
+}
+
 func (discovery *Discovery) RegisterNode(node *DiscoveryNode) error {
-	leaseID, cancel, err := discovery.getNodeStateGrantID(node.NodeKeepLive)
-	if err != nil {
-		return err
+	state := &discoveryNodeState{node: *node}
+	state.nodeCtx, state.nodeCancel = context.WithCancel(context.Background())
+	if node.NodeHealthCheck != nil {
+		if err := node.NodeHealthCheck(); err != nil {
+			state.unhealthy = true
+			discovery.storeNode(node.NodeKey, state)
+			state.event(DiscoveryNodeUnhealthy, err)
+			go discovery.checkNode(state)
+			return nil
+		}
 	}
-	err = discovery.SetData(node.NodeKey, string(node.NodeData), clientv3.WithLease(leaseID))
+	ch, err := discovery.grantHealthyNode(state, 0)
 	if err != nil {
+		state.nodeCancel()
 		return err
 	}
-	discovery.storeNode(node.NodeKey, cancel)
+	discovery.storeNode(node.NodeKey, state)
+	go discovery.superviseNode(state, ch, 0)
+	if node.NodeHealthCheck != nil {
+		go discovery.checkNode(state)
+	}
 	return nil
 }
 
 func (discovery *Discovery) UnRegisterNode(nodeKey string) error {
-	_, err := discovery.Client.Delete(discovery.getRequestContext(), nodeKey)
+	discovery.delNode(nodeKey)
+	err := discovery.DelData(nodeKey)
 	if err != nil {
 		return err
 	}
-	discovery.delNode(nodeKey)
 	return nil
 }
 
-func (discovery *Discovery) getNodeStateGrantID(nodeKeepLive int64) (clientv3.LeaseID, context.CancelFunc, error) {
-	resp, err := discovery.Client.Grant(discovery.getRequestContext(), nodeKeepLive)
+// grantHealthyNode 节点健康且generation未变化时申请租约并保持, 之后将节点数据绑定到该租约
+// 网络请求期间不持有state.lock, 完成后再次检查, 期间节点被暂停或注销时撤销新申请的租约
+func (discovery *Discovery) grantHealthyNode(state *discoveryNodeState, generation int64) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	if !state.isHealthyGeneration(generation) {
+		return nil, errNodeUnhealthy
+	}
+	reqCtx, reqCancel := discovery.getRequestContextWithParent(state.nodeCtx)
+	defer reqCancel()
+	leaseID, err := discovery.Backend.Grant(reqCtx, state.node.NodeKeepLive)
 	if err != nil {
-		return resp.ID, nil, err
+		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	ch, err := discovery.Client.KeepAlive(ctx, resp.ID)
+	leaseCtx, leaseCancel := context.WithCancel(state.nodeCtx)
+	ch, err := discovery.Backend.KeepAlive(leaseCtx, leaseID)
 	if err != nil {
-		return resp.ID, nil, err
+		leaseCancel()
+		discovery.revokeLease(leaseID)
+		return nil, err
 	}
-	go func() {
-		for {
-			_, ok := <-ch
-			if !ok {
-				return
+	_, err = discovery.Backend.Put(reqCtx, state.node.NodeKey, string(state.node.NodeData), leaseID)
+	if err != nil {
+		leaseCancel()
+		discovery.revokeLease(leaseID)
+		return nil, err
+	}
+	state.lock.Lock()
+	if state.unhealthy || state.generation != generation || state.nodeCtx.Err() != nil {
+		state.lock.Unlock()
+		leaseCancel()
+		discovery.revokeLease(leaseID)
+		return nil, errNodeUnhealthy
+	}
+	state.leaseID.Store(int64(leaseID))
+	state.leaseCancel = leaseCancel
+	state.lock.Unlock()
+	return ch, nil
+}
+
+// superviseNode 保持租约的通道关闭且节点未被注销时, 视为租约丢失并重新注册
+// 因健康检查失败主动注销时generation已变化, 直接退出
+func (discovery *Discovery) superviseNode(state *discoveryNodeState, ch <-chan *clientv3.LeaseKeepAliveResponse, generation int64) {
+	for {
+		for range ch {
+		}
+		if state.nodeCtx.Err() != nil || !state.isGeneration(generation) {
+			return
+		}
+		state.event(DiscoveryNodeLeaseLost, nil)
+		ch = discovery.reRegisterNode(state, generation, retryMinDelay)
+		if ch == nil {
+			return
+		}
+		state.event(DiscoveryNodeReRegistered, nil)
+	}
+}
+
+func (discovery *Discovery) reRegisterNode(state *discoveryNodeState, generation int64,
+	delay time.Duration) <-chan *clientv3.LeaseKeepAliveResponse {
+	for {
+		select {
+		case <-state.nodeCtx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		ch, err := discovery.grantHealthyNode(state, generation)
+		if err == nil {
+			return ch
+		}
+		if state.nodeCtx.Err() != nil || errors.Is(err, errNodeUnhealthy) {
+			return nil
+		}
+		state.event(DiscoveryNodeReRegisterFailed, err)
+		if delay < retryMinDelay {
+			delay = retryMinDelay
+		} else {
+			delay = nextRetryDelay(delay)
+		}
+	}
+}
+
+// checkNode 按间隔执行健康检查, 直到节点被注销
+func (discovery *Discovery) checkNode(state *discoveryNodeState) {
+	interval := state.node.NodeHealthInterval
+	if interval <= 0 {
+		interval = defaultNodeHealthInterval
+	}
+	threshold := state.node.NodeHealthThreshold
+	if threshold <= 0 {
+		threshold = defaultNodeHealthThreshold
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-state.nodeCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := state.node.NodeHealthCheck()
+		if err != nil {
+			failures++
+			if failures == threshold {
+				discovery.pauseNode(state, err)
 			}
+			continue
+		}
+		failures = 0
+		discovery.resumeNode(state)
+	}
+}
+
+// pauseNode 健康检查失败, 停止保持租约并撤销租约, 节点数据随租约删除
+func (discovery *Discovery) pauseNode(state *discoveryNodeState, err error) {
+	state.lock.Lock()
+	if state.unhealthy {
+		state.lock.Unlock()
+		return
+	}
+	state.unhealthy = true
+	state.generation++
+	leaseID := clientv3.LeaseID(state.leaseID.Swap(int64(clientv3.NoLease)))
+	if state.leaseCancel != nil {
+		state.leaseCancel()
+		state.leaseCancel = nil
+	}
+	state.lock.Unlock()
+	if leaseID != clientv3.NoLease {
+		discovery.revokeLease(leaseID)
+	}
+	state.event(DiscoveryNodeUnhealthy, err)
+}
+
+// resumeNode 健康检查恢复, 立即重新注册, 失败时按退避间隔重试
+func (discovery *Discovery) resumeNode(state *discoveryNodeState) {
+	state.lock.Lock()
+	if !state.unhealthy {
+		state.lock.Unlock()
+		return
+	}
+	state.unhealthy = false
+	generation := state.generation
+	state.lock.Unlock()
+	state.event(DiscoveryNodeRecovered, nil)
+	go func() {
+		ch := discovery.reRegisterNode(state, generation, 0)
+		if ch == nil {
+			return
 		}
+		state.event(DiscoveryNodeReRegistered, nil)
+		discovery.superviseNode(state, ch, generation)
 	}()
-	return resp.ID, cancel, nil
 }
 
-func (discovery *Discovery) storeNode(nodeKey string, cancel context.CancelFunc) {
-	oldCancel, ok := discovery.discoveryNodeMap.Load(nodeKey)
-	if ok && cancel != nil {
-		oldCancel.(context.CancelFunc)()
+func (discovery *Discovery) revokeLease(leaseID clientv3.LeaseID) {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	discovery.Backend.Revoke(ctx, leaseID)
+}
+
+func (discovery *Discovery) storeNode(nodeKey string, state *discoveryNodeState) {
+	if oldState, ok := discovery.nodeRegistry.store(nodeKey, state); ok {
+		oldState.nodeCancel()
 	}
-	discovery.discoveryNodeMap.Store(nodeKey, cancel)
 }
 
 func (discovery *Discovery) delNode(nodeKey string) {
-	cancel, ok := discovery.discoveryNodeMap.Load(nodeKey)
-	if ok && cancel != nil {
-		cancel.(context.CancelFunc)()
+	state, ok := discovery.nodeRegistry.remove(nodeKey)
+	if ok {
+		state.nodeCancel()
+		discovery.revokeLease(clientv3.LeaseID(state.leaseID.Load()))
+	}
+}
+
+func (state *discoveryNodeState) isGeneration(generation int64) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.generation == generation
+}
+
+func (state *discoveryNodeState) isHealthyGeneration(generation int64) bool {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return !state.unhealthy && state.generation == generation
+}
+
+func (state *discoveryNodeState) event(event DiscoveryNodeEvent, err error) {
+	if state.node.NodeEventCall != nil {
+		state.node.NodeEventCall(state.node.NodeKey, event, err)
 	}
-	discovery.discoveryNodeMap.Delete(nodeKey)
 }
 
 func (discovery *Discovery) RegisterNodeWatch(watchNode *DiscoveryWatchNode) error {
-	watchNode.nodeCtx, watchNode.nodeCancel = context.WithCancel(context.Background())
-	err := discovery.getNodeWatch(watchNode)
+	kvs, revision, err := discovery.readNodeWatch(watchNode)
 	if err != nil {
 		return err
 	}
-	go discovery.startNodeWatch(watchNode)
+	watchNode.nodeCtx, watchNode.nodeCancel = context.WithCancel(context.Background())
+	go discovery.startNodeWatch(watchNode, kvs, revision)
+	if oldWatch, ok := discovery.nodeWatchRegistry.store(watchNode.NodeKey, watchNode); ok && oldWatch != watchNode {
+		oldWatch.nodeCancel()
+	}
 	return nil
 }
 
 func (discovery *Discovery) UnRegisterNodeWatch(watchKey string) error {
-	watch, ok := discovery.DiscoveryWatchNodeMap[watchKey]
+	watch, ok := discovery.nodeWatchRegistry.remove(watchKey)
 	if !ok {
-		return errors.New("watch config " + watchKey + " is not found")
+		return errors.New("watch node " + watchKey + " is not found")
 	}
 	watch.nodeCancel()
-	delete(discovery.DiscoveryWatchNodeMap, watchKey)
 	return nil
 }
 
-func (discovery *Discovery) getNodeWatch(watchNode *DiscoveryWatchNode) error {
-	val, err := discovery.GetData(watchNode.NodeKey)
+func (discovery *Discovery) readNodeWatch(watchNode *DiscoveryWatchNode) ([]*mvccpb.KeyValue, int64, error) {
+	kvs, revision, err := discovery.getDataWithRevision(watchNode.NodeKey, false)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	watchNode.NodeCall.NodeConnect(val)
-	return nil
+	if len(kvs) == 0 {
+		return nil, 0, errors.New("Key " + watchNode.NodeKey + " is not found")
+	}
+	watchNode.NodeCall.NodeConnect(kvs[0].Value)
+	return kvs, revision, nil
 }
 
-func (discovery *Discovery) startNodeWatch(watchNode *DiscoveryWatchNode) {
-	discovery.WatchData(watchNode.nodeCtx, watchNode.NodeKey, func(ev *clientv3.Event) {
+// startNodeWatch 从读取节点时的版本开始监听, 直到UnRegisterNodeWatch
+func (discovery *Discovery) startNodeWatch(watchNode *DiscoveryWatchNode, kvs []*mvccpb.KeyValue, revision int64) {
+	discovery.newWatcher(watchNode.NodeKey, false, kvs, revision+1, func(ev *clientv3.Event) {
 		switch ev.Type {
 		case clientv3.EventTypePut:
 			watchNode.NodeCall.NodeConnect(ev.Kv.Value)
 		case clientv3.EventTypeDelete:
-			watchNode.NodeCall.NodeDisconnect(ev.PrevKv.Value)
+			var nodeData []byte
+			if ev.PrevKv != nil {
+				nodeData = ev.PrevKv.Value
+			}
+			watchNode.NodeCall.NodeDisconnect(nodeData)
 		}
-	})
+	}).run(watchNode.nodeCtx)
 }
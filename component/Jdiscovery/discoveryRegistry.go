@@ -0,0 +1,198 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/client/v3"
+	"sort"
+	"sync"
+)
+
+// discoveryRegistry 并发安全的注册表, 键为注册时使用的ConfigKey, NodeKey, ServicePrefix等
+type discoveryRegistry[T any] struct {
+	lock     sync.RWMutex
+	entryMap map[string]T
+}
+
+// DiscoveryNodeStatus 通过RegisterNode注册的节点状态, 节点因健康检查失败被注销时Healthy为false, LeaseID为NoLease
+type DiscoveryNodeStatus struct {
+	NodeKey string
+	LeaseID clientv3.LeaseID
+	Healthy bool
+}
+
+// store 写入注册表, 返回被替换的旧值
+func (registry *discoveryRegistry[T]) store(key string, entry T) (T, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if registry.entryMap == nil {
+		registry.entryMap = make(map[string]T)
+	}
+	oldEntry, ok := registry.entryMap[key]
+	registry.entryMap[key] = entry
+	return oldEntry, ok
+}
+
+// storeIfAbsent 键不存在时写入, 返回是否写入
+func (registry *discoveryRegistry[T]) storeIfAbsent(key string, entry T) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.entryMap[key]; ok {
+		return false
+	}
+	if registry.entryMap == nil {
+		registry.entryMap = make(map[string]T)
+	}
+	registry.entryMap[key] = entry
+	return true
+}
+
+func (registry *discoveryRegistry[T]) load(key string) (T, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	entry, ok := registry.entryMap[key]
+	return entry, ok
+}
+
+func (registry *discoveryRegistry[T]) remove(key string) (T, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	entry, ok := registry.entryMap[key]
+	delete(registry.entryMap, key)
+	return entry, ok
+}
+
+// removeIf 只在当前值满足match时删除, 避免删除同一个键上后注册的值
+func (registry *discoveryRegistry[T]) removeIf(key string, match func(entry T) bool) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	entry, ok := registry.entryMap[key]
+	if !ok || !match(entry) {
+		return false
+	}
+	delete(registry.entryMap, key)
+	return true
+}
+
+func (registry *discoveryRegistry[T]) removeAll() []T {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	entryList := make([]T, 0, len(registry.entryMap))
+	for _, entry := range registry.entryMap {
+		entryList = append(entryList, entry)
+	}
+	registry.entryMap = nil
+	return entryList
+}
+
+func (registry *discoveryRegistry[T]) keys() []string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	keyList := make([]string, 0, len(registry.entryMap))
+	for key := range registry.entryMap {
+		keyList = append(keyList, key)
+	}
+	sort.Strings(keyList)
+	return keyList
+}
+
+// ListConfigWatch 获取RegisterConfigWatch注册的所有配置键
+func (discovery *Discovery) ListConfigWatch() []string {
+	return discovery.configWatchRegistry.keys()
+}
+
+func (discovery *Discovery) GetConfigWatch(configKey string) (*DiscoveryConfig, bool) {
+	return discovery.configWatchRegistry.load(configKey)
+}
+
+// ListNodeWatch 获取RegisterNodeWatch注册的所有节点键
+func (discovery *Discovery) ListNodeWatch() []string {
+	return discovery.nodeWatchRegistry.keys()
+}
+
+func (discovery *Discovery) GetNodeWatch(nodeKey string) (*DiscoveryWatchNode, bool) {
+	return discovery.nodeWatchRegistry.load(nodeKey)
+}
+
+// ListServiceWatch 获取RegisterServiceWatch注册的所有服务前缀
+func (discovery *Discovery) ListServiceWatch() []string {
+	return discovery.serviceWatchRegistry.keys()
+}
+
+func (discovery *Discovery) GetServiceWatch(servicePrefix string) (*DiscoveryWatchService, bool) {
+	return discovery.serviceWatchRegistry.load(getServicePrefix(servicePrefix))
+}
+
+// ListElection 获取正在竞选的选举名称
+func (discovery *Discovery) ListElection() []string {
+	return discovery.electionRegistry.keys()
+}
+
+// ListNode 获取RegisterNode注册的所有节点键
+func (discovery *Discovery) ListNode() []string {
+	return discovery.nodeRegistry.keys()
+}
+
+func (discovery *Discovery) GetNodeStatus(nodeKey string) (DiscoveryNodeStatus, bool) {
+	state, ok := discovery.nodeRegistry.load(nodeKey)
+	if !ok {
+		return DiscoveryNodeStatus{}, false
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return DiscoveryNodeStatus{NodeKey: nodeKey, LeaseID: clientv3.LeaseID(state.leaseID.Load()),
+		Healthy: !state.unhealthy}, true
+}
+
+// Close 取消所有监听, 停止所有竞选, 撤销RegisterNode注册的所有节点的租约(节点数据随租约删除), 最后关闭后端
+// 撤销租约使用ctx, 并受RequestTimeout限制, 撤销失败的错误与关闭后端的错误一起返回
+func (discovery *Discovery) Close(ctx context.Context) error {
+	if !discovery.closed.CompareAndSwap(false, true) {
+		return errors.New("discovery is closed")
+	}
+	for _, watch := range discovery.configWatchRegistry.removeAll() {
+		watch.configCancel()
+	}
+	for _, watch := range discovery.nodeWatchRegistry.removeAll() {
+		watch.nodeCancel()
+	}
+	for _, watch := range discovery.serviceWatchRegistry.removeAll() {
+		watch.serviceCancel()
+	}
+	for _, election := range discovery.electionRegistry.removeAll() {
+		election.resign()
+	}
+	var errList []error
+	for _, state := range discovery.nodeRegistry.removeAll() {
+		state.nodeCancel()
+		leaseID := clientv3.LeaseID(state.leaseID.Load())
+		if leaseID == clientv3.NoLease {
+			continue
+		}
+		reqCtx, reqCancel := discovery.getRequestContextWithParent(ctx)
+		if err := discovery.Backend.Revoke(reqCtx, leaseID); err != nil {
+			errList = append(errList, errors.New("revoke lease of node "+state.node.NodeKey+": "+err.Error()))
+		}
+		reqCancel()
+	}
+	if err := discovery.Backend.Close(); err != nil {
+		errList = append(errList, err)
+	}
+	return errors.Join(errList...)
+}
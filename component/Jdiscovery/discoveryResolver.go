@@ -0,0 +1,195 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"errors"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/resolver"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 使用方式: RegisterResolver后, 以 jdisc:///服务名 作为Jrpc.RpcClientConfig.Addr
+// 服务名对应的实例前缀为 DiscoveryResolverConfig.ServicePrefix/服务名, 实例通过RegisterServiceInstance注册
+const (
+	DiscoveryResolverScheme   = "jdisc"
+	DiscoveryBalancerWeighted = "jdisc_weighted"
+)
+
+// Filter 只解析满足条件的实例, 例如只连接同一Zone或指定Version的实例
+type DiscoveryResolverConfig struct {
+	ServicePrefix string
+	Balancer      string
+	Filter        ServiceInstanceFilter
+}
+
+type discoveryResolverBuilder struct {
+	discovery *Discovery
+	config    DiscoveryResolverConfig
+}
+
+type discoveryResolver struct {
+	cc      resolver.ClientConn
+	config  DiscoveryResolverConfig
+	watch   *DiscoveryWatchService
+	lock    sync.Mutex
+	isReady bool
+}
+
+type discoveryWeightKey struct{}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(DiscoveryBalancerWeighted, &discoveryWeightedPickerBuilder{},
+		base.Config{HealthCheck: true}))
+}
+
+// RegisterResolver 将jdisc解析器注册到grpc全局, 需要在拨号前调用
+func RegisterResolver(discovery *Discovery, config DiscoveryResolverConfig) {
+	resolver.Register(NewResolverBuilder(discovery, config))
+}
+
+// NewResolverBuilder 创建jdisc解析器, 可以通过grpc.WithResolvers只在单个连接中使用
+// Balancer为空时使用round_robin, 按权重负载均衡时使用DiscoveryBalancerWeighted
+func NewResolverBuilder(discovery *Discovery, config DiscoveryResolverConfig) resolver.Builder {
+	if config.Balancer == "" {
+		config.Balancer = roundrobin.Name
+	}
+	return &discoveryResolverBuilder{discovery: discovery, config: config}
+}
+
+func (builder *discoveryResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceName := strings.TrimPrefix(target.URL.Path, "/")
+	if serviceName == "" {
+		serviceName = target.URL.Opaque
+	}
+	if serviceName == "" {
+		return nil, errors.New("service name is empty in target " + target.URL.String())
+	}
+	r := &discoveryResolver{cc: cc, config: builder.config}
+	r.watch = &DiscoveryWatchService{ServicePrefix: GetServiceNamePrefix(builder.config.ServicePrefix, serviceName)}
+	r.watch.ServiceCall.InstanceAdd = r.onInstanceChange
+	r.watch.ServiceCall.InstanceUpdate = r.onInstanceChange
+	r.watch.ServiceCall.InstanceRemove = r.onInstanceChange
+	if err := builder.discovery.startServiceWatch(r.watch); err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.isReady = true
+	r.lock.Unlock()
+	r.updateState()
+	return r, nil
+}
+
+func (builder *discoveryResolverBuilder) Scheme() string {
+	return DiscoveryResolverScheme
+}
+
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *discoveryResolver) Close() {
+	r.watch.serviceCancel()
+}
+
+func (r *discoveryResolver) onInstanceChange(instanceKey string, instanceData []byte) {
+	r.updateState()
+}
+
+func (r *discoveryResolver) updateState() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.isReady {
+		return
+	}
+	instanceMap := r.watch.GetInstances()
+	addrList := make([]resolver.Address, 0, len(instanceMap))
+	for _, data := range instanceMap {
+		addr, weight, ok := r.parseInstance(data)
+		if !ok || addr == "" {
+			continue
+		}
+		addrList = append(addrList, resolver.Address{Addr: addr,
+			Attributes: attributes.New(discoveryWeightKey{}, weight)})
+	}
+	sort.Slice(addrList, func(i, j int) bool {
+		return addrList[i].Addr < addrList[j].Addr
+	})
+	state := resolver.State{Addresses: addrList}
+	state.ServiceConfig = r.cc.ParseServiceConfig(`{"loadBalancingConfig":[{"` + r.config.Balancer + `":{}}]}`)
+	r.cc.UpdateState(state)
+}
+
+// parseInstance 实例数据为ServiceInstance时按Filter过滤并读取地址和权重, 否则整个实例数据视为地址
+func (r *discoveryResolver) parseInstance(data []byte) (string, int, bool) {
+	instance, err := ParseServiceInstance(data)
+	if err != nil {
+		return strings.TrimSpace(string(data)), 1, true
+	}
+	if !r.config.Filter.Match(instance) {
+		return "", 0, false
+	}
+	return instance.Endpoint(), instance.Weight, true
+}
+
+type discoveryWeightedPickerBuilder struct{}
+
+// discoveryWeightedPicker 平滑加权轮询
+type discoveryWeightedPicker struct {
+	lock     sync.Mutex
+	itemList []*discoveryWeightedItem
+	total    int
+}
+
+type discoveryWeightedItem struct {
+	subConn balancer.SubConn
+	weight  int
+	current int
+}
+
+func (builder *discoveryWeightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	picker := &discoveryWeightedPicker{}
+	for subConn, subConnInfo := range info.ReadySCs {
+		weight, ok := subConnInfo.Address.Attributes.Value(discoveryWeightKey{}).(int)
+		if !ok || weight <= 0 {
+			weight = 1
+		}
+		picker.itemList = append(picker.itemList, &discoveryWeightedItem{subConn: subConn, weight: weight})
+		picker.total += weight
+	}
+	return picker
+}
+
+func (picker *discoveryWeightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	picker.lock.Lock()
+	defer picker.lock.Unlock()
+	var best *discoveryWeightedItem
+	for _, item := range picker.itemList {
+		item.current += item.weight
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+	best.current -= picker.total
+	return balancer.PickResult{SubConn: best.subConn}, nil
+}
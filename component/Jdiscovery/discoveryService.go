@@ -0,0 +1,155 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.etcd.io/etcd/client/v3"
+	"strings"
+	"sync"
+)
+
+type discoveryWatchServiceCall struct {
+	InstanceAdd    func(instanceKey string, instanceData []byte)
+	InstanceUpdate func(instanceKey string, instanceData []byte)
+	InstanceRemove func(instanceKey string, instanceData []byte)
+}
+
+// DiscoveryWatchService 监听服务前缀下的所有实例, 并在本地维护当前存活的实例集合
+type DiscoveryWatchService struct {
+	ServicePrefix string
+	ServiceCall   discoveryWatchServiceCall
+	instanceLock  sync.RWMutex
+	instanceMap   map[string][]byte
+	serviceCtx    context.Context
+	serviceCancel context.CancelFunc
+}
+
+// GetServiceInstanceKey 获取服务实例的注册键, 例如 /service/user + 1 => /service/user/1
+func GetServiceInstanceKey(servicePrefix string, instanceID string) string {
+	return getServicePrefix(servicePrefix) + instanceID
+}
+
+// RegisterServiceNode 将节点注册到服务前缀下, node.NodeKey为实例ID
+func (discovery *Discovery) RegisterServiceNode(servicePrefix string, node *DiscoveryNode) error {
+	if servicePrefix == "" {
+		return errors.New("service prefix is empty")
+	}
+	if node.NodeKey == "" {
+		return errors.New("node key is empty")
+	}
+	serviceNode := *node
+	serviceNode.NodeKey = GetServiceInstanceKey(servicePrefix, node.NodeKey)
+	return discovery.RegisterNode(&serviceNode)
+}
+
+func (discovery *Discovery) UnRegisterServiceNode(servicePrefix string, instanceID string) error {
+	return discovery.UnRegisterNode(GetServiceInstanceKey(servicePrefix, instanceID))
+}
+
+// RegisterServiceWatch 读取服务前缀下的所有实例并依次调用InstanceAdd, 之后从读取时的版本开始监听变化
+func (discovery *Discovery) RegisterServiceWatch(watchService *DiscoveryWatchService) error {
+	if watchService.ServicePrefix == "" {
+		return errors.New("service prefix is empty")
+	}
+	if err := discovery.startServiceWatch(watchService); err != nil {
+		return err
+	}
+	if oldWatch, ok := discovery.serviceWatchRegistry.store(watchService.ServicePrefix, watchService); ok && oldWatch != watchService {
+		oldWatch.serviceCancel()
+	}
+	return nil
+}
+
+func (discovery *Discovery) UnRegisterServiceWatch(servicePrefix string) error {
+	servicePrefix = getServicePrefix(servicePrefix)
+	watch, ok := discovery.serviceWatchRegistry.remove(servicePrefix)
+	if !ok {
+		return errors.New("watch service " + servicePrefix + " is not found")
+	}
+	watch.serviceCancel()
+	return nil
+}
+
+// GetInstances 获取当前存活的实例, 键为实例的注册键
+func (watchService *DiscoveryWatchService) GetInstances() map[string][]byte {
+	watchService.instanceLock.RLock()
+	defer watchService.instanceLock.RUnlock()
+	instanceMap := make(map[string][]byte, len(watchService.instanceMap))
+	for key, data := range watchService.instanceMap {
+		instanceMap[key] = data
+	}
+	return instanceMap
+}
+
+func (discovery *Discovery) startServiceWatch(watchService *DiscoveryWatchService) error {
+	watchService.ServicePrefix = getServicePrefix(watchService.ServicePrefix)
+	kvs, revision, err := discovery.getDataWithRevision(watchService.ServicePrefix, true)
+	if err != nil {
+		return err
+	}
+	watchService.serviceCtx, watchService.serviceCancel = context.WithCancel(context.Background())
+	watchService.instanceLock.Lock()
+	watchService.instanceMap = make(map[string][]byte)
+	watchService.instanceLock.Unlock()
+	for _, kv := range kvs {
+		watchService.putInstance(string(kv.Key), kv.Value)
+	}
+	go discovery.newWatcher(watchService.ServicePrefix, true, kvs, revision+1, watchService.onEvent).run(watchService.serviceCtx)
+	return nil
+}
+
+func (watchService *DiscoveryWatchService) onEvent(ev *clientv3.Event) {
+	switch ev.Type {
+	case clientv3.EventTypePut:
+		watchService.putInstance(string(ev.Kv.Key), ev.Kv.Value)
+	case clientv3.EventTypeDelete:
+		watchService.removeInstance(string(ev.Kv.Key))
+	}
+}
+
+func (watchService *DiscoveryWatchService) putInstance(key string, data []byte) {
+	watchService.instanceLock.Lock()
+	oldData, ok := watchService.instanceMap[key]
+	watchService.instanceMap[key] = data
+	watchService.instanceLock.Unlock()
+	if !ok {
+		if watchService.ServiceCall.InstanceAdd != nil {
+			watchService.ServiceCall.InstanceAdd(key, data)
+		}
+		return
+	}
+	if !bytes.Equal(oldData, data) && watchService.ServiceCall.InstanceUpdate != nil {
+		watchService.ServiceCall.InstanceUpdate(key, data)
+	}
+}
+
+func (watchService *DiscoveryWatchService) removeInstance(key string) {
+	watchService.instanceLock.Lock()
+	data, ok := watchService.instanceMap[key]
+	delete(watchService.instanceMap, key)
+	watchService.instanceLock.Unlock()
+	if ok && watchService.ServiceCall.InstanceRemove != nil {
+		watchService.ServiceCall.InstanceRemove(key, data)
+	}
+}
+
+func getServicePrefix(servicePrefix string) string {
+	return strings.TrimSuffix(servicePrefix, "/") + "/"
+}
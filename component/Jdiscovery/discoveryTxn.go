@@ -0,0 +1,132 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrConfigConflict 写入时配置已被其他人修改
+var ErrConfigConflict = errors.New("config has been modified by another writer")
+
+// DiscoveryTxn 多个键的事务, 所有IfVersion条件成立时原子地执行全部Put和Delete, 否则Commit返回ErrConfigConflict
+// Put的键和SetConfig一样记录历史版本
+type DiscoveryTxn struct {
+	discovery   *Discovery
+	change      DiscoveryConfigChange
+	compareList []DiscoveryCompare
+	opList      []DiscoveryOp
+}
+
+// GetConfigWithVersion 获取配置和当前版本号, 版本号可以用于SetConfigIfVersion
+func (discovery *Discovery) GetConfigWithVersion(configKey string) ([]byte, int64, error) {
+	kvs, _, err := discovery.getConfigWithRevision(configKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return kvs[0].Value, kvs[0].ModRevision, nil
+}
+
+// SetConfigIfVersion 只在配置当前版本等于version时写入, version为0时要求配置不存在, 返回新版本号
+func (discovery *Discovery) SetConfigIfVersion(configKey string, data string, version int64,
+	change DiscoveryConfigChange) (int64, error) {
+	return discovery.setConfigVersion(configKey, data, []DiscoveryCompare{{Key: configKey, ModRevision: version}},
+		&DiscoveryConfigVersion{
+			Author:  change.Author,
+			Comment: change.Comment,
+			Action:  DiscoveryConfigSet,
+		})
+}
+
+// CompareAndSwap 只在配置当前内容等于oldData时写入newData, 返回新版本号
+func (discovery *Discovery) CompareAndSwap(configKey string, oldData string, newData string,
+	change DiscoveryConfigChange) (int64, error) {
+	data, version, err := discovery.GetConfigWithVersion(configKey)
+	if err != nil {
+		return 0, err
+	}
+	if string(data) != oldData {
+		return 0, ErrConfigConflict
+	}
+	return discovery.SetConfigIfVersion(configKey, newData, version, change)
+}
+
+func (discovery *Discovery) NewTxn(change DiscoveryConfigChange) *DiscoveryTxn {
+	return &DiscoveryTxn{discovery: discovery, change: change}
+}
+
+// IfVersion 要求key的当前版本等于version, version为0时要求key不存在
+func (txn *DiscoveryTxn) IfVersion(key string, version int64) *DiscoveryTxn {
+	txn.compareList = append(txn.compareList, DiscoveryCompare{Key: key, ModRevision: version})
+	return txn
+}
+
+func (txn *DiscoveryTxn) Put(key string, data string) *DiscoveryTxn {
+	txn.opList = append(txn.opList, DiscoveryOp{Key: key, Value: data})
+	return txn
+}
+
+func (txn *DiscoveryTxn) Delete(key string) *DiscoveryTxn {
+	txn.opList = append(txn.opList, DiscoveryOp{Key: key, Delete: true})
+	return txn
+}
+
+// Commit 提交事务, 返回新版本号, Put的键的历史记录与事务中的其它操作一起原子地写入
+// 与etcd一致, 同一个键在事务中只能操作一次, 所有键一共最多删除configHistoryTrimLimit条旧历史
+func (txn *DiscoveryTxn) Commit() (int64, error) {
+	keyMap := make(map[string]struct{}, len(txn.opList))
+	for _, op := range txn.opList {
+		if _, ok := keyMap[op.Key]; ok {
+			return 0, errors.New("duplicate key " + op.Key + " in txn")
+		}
+		keyMap[op.Key] = struct{}{}
+	}
+	author := txn.change.Author
+	if author == "" {
+		author = getConfigAuthor()
+	}
+	opList := append([]DiscoveryOp(nil), txn.opList...)
+	trimLimit := configHistoryTrimLimit
+	for _, op := range txn.opList {
+		if op.Delete {
+			continue
+		}
+		historyOpList, err := txn.discovery.getConfigHistoryOps(op.Key, &DiscoveryConfigVersion{
+			Data:    []byte(op.Value),
+			Author:  author,
+			Comment: txn.change.Comment,
+			Action:  DiscoveryConfigSet,
+			Time:    time.Now().UTC(),
+		}, trimLimit)
+		if err != nil {
+			return 0, err
+		}
+		trimLimit -= len(historyOpList) - 1
+		opList = append(opList, historyOpList...)
+	}
+	ctx, cancel := txn.discovery.getRequestContext()
+	defer cancel()
+	revision, succeeded, err := txn.discovery.Backend.Txn(ctx, txn.compareList, opList)
+	if err != nil {
+		return 0, err
+	}
+	if !succeeded {
+		return 0, ErrConfigConflict
+	}
+	return revision, nil
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2021 liyiligang.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package Jdiscovery
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"sort"
+	"time"
+)
+
+// discoveryWatcher 记录已收到的最新版本和键值, 监听中断后从最新版本继续
+// 历史版本已被压缩时重新读取全部键值, 与本地记录对比后以合成的Put/Delete事件回调, 之后从读取时的版本继续监听
+type discoveryWatcher struct {
+	discovery *Discovery
+	key       string
+	prefix    bool
+	revision  int64
+	kvMap     map[string]*mvccpb.KeyValue
+	call      func(ev *clientv3.Event)
+}
+
+// newWatcher kvs为监听前已读取的键值, 从revision开始监听, revision为0时从当前版本开始监听
+func (discovery *Discovery) newWatcher(key string, prefix bool, kvs []*mvccpb.KeyValue, revision int64,
+	call func(ev *clientv3.Event)) *discoveryWatcher {
+	watcher := &discoveryWatcher{discovery: discovery, key: key, prefix: prefix, revision: revision,
+		kvMap: make(map[string]*mvccpb.KeyValue, len(kvs)), call: call}
+	for _, kv := range kvs {
+		watcher.kvMap[string(kv.Key)] = kv
+	}
+	return watcher
+}
+
+// run 监听直到ctx取消
+func (watcher *discoveryWatcher) run(ctx context.Context) {
+	delay := retryMinDelay
+	for ctx.Err() == nil {
+		compacted, received := watcher.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			delay = retryMinDelay
+		}
+		if compacted {
+			if err := watcher.resync(ctx); err == nil {
+				delay = retryMinDelay
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = nextRetryDelay(delay)
+	}
+}
+
+func (watcher *discoveryWatcher) watch(ctx context.Context) (compacted bool, received bool) {
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+	for res := range watcher.discovery.Backend.Watch(watchCtx, watcher.key, watcher.prefix, watcher.revision) {
+		if res.CompactRevision != 0 || errors.Is(res.Err, rpctypes.ErrCompacted) {
+			return true, received
+		}
+		if res.Err != nil {
+			return false, received
+		}
+		for _, ev := range res.Events {
+			received = true
+			watcher.apply(ev)
+		}
+	}
+	return false, received
+}
+
+// apply 后端返回的事件可能被多个监听共享, 补充PrevKv时使用事件的副本
+func (watcher *discoveryWatcher) apply(ev *clientv3.Event) {
+	key := string(ev.Kv.Key)
+	if ev.PrevKv == nil {
+		evCopy := *ev
+		evCopy.PrevKv = watcher.kvMap[key]
+		ev = &evCopy
+	}
+	if ev.Type == clientv3.EventTypeDelete {
+		delete(watcher.kvMap, key)
+	} else {
+		watcher.kvMap[key] = ev.Kv
+	}
+	watcher.revision = ev.Kv.ModRevision + 1
+	watcher.call(ev)
+}
+
+// resync 重新读取全部键值并回调与本地记录不同的部分
+func (watcher *discoveryWatcher) resync(ctx context.Context) error {
+	reqCtx, reqCancel := watcher.discovery.getRequestContextWithParent(ctx)
+	defer reqCancel()
+	kvs, revision, err := watcher.discovery.Backend.Get(reqCtx, watcher.key, watcher.prefix)
+	if err != nil {
+		return err
+	}
+	var evList []*clientv3.Event
+	kvMap := make(map[string]*mvccpb.KeyValue, len(kvs))
+	for _, kv := range kvs {
+		kvMap[string(kv.Key)] = kv
+		prevKv := watcher.kvMap[string(kv.Key)]
+		if prevKv == nil || prevKv.ModRevision != kv.ModRevision {
+			evList = append(evList, &clientv3.Event{Type: clientv3.EventTypePut, Kv: kv, PrevKv: prevKv})
+		}
+	}
+	for key, prevKv := range watcher.kvMap {
+		if _, ok := kvMap[key]; !ok {
+			evList = append(evList, &clientv3.Event{Type: clientv3.EventTypeDelete,
+				Kv: &mvccpb.KeyValue{Key: prevKv.Key, ModRevision: revision}, PrevKv: prevKv})
+		}
+	}
+	sort.Slice(evList, func(i, j int) bool {
+		return string(evList[i].Kv.Key) < string(evList[j].Kv.Key)
+	})
+	watcher.kvMap = kvMap
+	watcher.revision = revision + 1
+	for _, ev := range evList {
+		if ctx.Err() != nil {
+			return nil
+		}
+		watcher.call(ev)
+	}
+	return nil
+}
+
+// getDataWithRevision 读取键值和读取时的版本, 用于之后从该版本开始监听
+func (discovery *Discovery) getDataWithRevision(key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	ctx, cancel := discovery.getRequestContext()
+	defer cancel()
+	return discovery.Backend.Get(ctx, key, prefix)
+}
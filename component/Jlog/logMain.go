@@ -26,14 +26,14 @@ import (
 var logHandle *zap.SugaredLogger
 
 type LogConfig struct {
-	Debug         bool
-	Path          string
-	Level         string
-	MaxSize       int
-	MaxBackups    int
-	MaxAge        int
-	Json          bool
-	InitialFields map[string]interface{}
+	Debug         bool                   `comment:"开发模式"`
+	Path          string                 `comment:"日志文件路径"`
+	Level         string                 `default:"debug" comment:"日志级别: debug, info, warn, error, dpanic, panic, fatal"`
+	MaxSize       int                    `default:"100" comment:"每个日志文件保存的最大尺寸, 单位: M"`
+	MaxBackups    int                    `comment:"最多保存多少个日志文件, 0为不限制"`
+	MaxAge        int                    `comment:"日志文件最多保存多少天, 0为不限制"`
+	Json          bool                   `comment:"是否以json格式输出"`
+	InitialFields map[string]interface{} `comment:"每条日志附带的固定字段"`
 }
 
 type logIOWrite struct{
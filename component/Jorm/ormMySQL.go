@@ -30,12 +30,12 @@ import (
 )
 
 type OrmConfig struct {
-	Name        string
-	SqlDsn      string
-	MaxKeepConn int
-	MaxConn     int
-	MaxLifetime time.Duration
-	ShowLog     bool
+	Name        string        `default:"sqlite" comment:"数据库类型: mysql, postgresql, sqlserver, sqlite"`
+	SqlDsn      string        `validate:"required" comment:"数据库连接字符串"`
+	MaxKeepConn int           `validate:"min=0" comment:"最大空闲连接数"`
+	MaxConn     int           `validate:"min=0" comment:"最大连接数, 0为不限制"`
+	MaxLifetime time.Duration `comment:"连接可复用的最长时间, 例如 1h, 0为不限制"`
+	ShowLog     bool          `comment:"是否输出全部sql日志, 关闭时只输出警告"`
 	LogWrite    io.Writer
 	schemaNamer schema.Namer
 	TableCheck  func(*gorm.DB) error
@@ -35,8 +35,8 @@ const ConstRpcHeader = "rpc-header-bin"
 
 
 type RpcBaseConfig struct {
-	Addr           string
-	PublicKeyPath  string
+	Addr           string `validate:"required"`
+	PublicKeyPath  string `validate:"file"`
 }
 
 type RpcServerConfig struct {
@@ -27,11 +27,11 @@ import (
 )
 
 type WebConfig struct {
-	Debug          bool
-	Origin         bool
-	Addr           string
-	PublicKeyPath  string
-	PrivateKeyPath string
+	Debug          bool   `comment:"调试模式, 关闭时gin运行于release模式"`
+	Origin         bool   `comment:"是否允许跨域访问"`
+	Addr           string `validate:"required,hostport" comment:"监听地址, 例如 0.0.0.0:8080"`
+	PublicKeyPath  string `validate:"file" comment:"TLS证书路径, 与私钥路径同时配置时启用https"`
+	PrivateKeyPath string `validate:"file" comment:"TLS私钥路径"`
 	AccessWrite    io.Writer
 	ErrorWrite     io.Writer
 	RouteCall      func(r *gin.Engine)